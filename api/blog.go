@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/scottfrazer/website/migrate"
+)
+
+const blogMigrationScope = "blog"
+
+var postgresBlogMigrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up:      migrate.Exec("CREATE TABLE IF NOT EXISTS blog (id bigserial, title text, date timestamp, content text)"),
+	},
+}
+
+var sqliteBlogMigrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up:      migrate.Exec("CREATE TABLE IF NOT EXISTS blog (id integer primary key autoincrement, title text, date timestamp, content text)"),
+	},
+}
+
+type BlogPost struct {
+	Id      int64     `json:"id"`
+	Title   string    `json:"title"`
+	Date    time.Time `json:"date"`
+	Content string    `json:"content"`
+}
+
+type BlogRepo interface {
+	List() ([]BlogPost, error)
+	Get(id int64) (*BlogPost, error)
+	GetLatest() (*BlogPost, error)
+	Set(blogPost BlogPost) error
+	Create(title string, date time.Time, content string) (BlogPost, error)
+}
+
+// PostgresBlogRepo is a BlogRepo backed by Postgres.
+type PostgresBlogRepo struct {
+	db *sql.DB
+}
+
+func NewPostgresBlogRepo(db *sql.DB) *PostgresBlogRepo {
+	r := &PostgresBlogRepo{db}
+	check(r.Init())
+	return r
+}
+
+func (repo PostgresBlogRepo) List() ([]BlogPost, error) {
+	rows, err := repo.db.Query("SELECT id, title, date, content FROM blog;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []BlogPost{}
+
+	for rows.Next() {
+		var id int64
+		var title string
+		var date time.Time
+		var content string
+		if err := rows.Scan(&id, &title, &date, &content); err != nil {
+			return nil, err
+		}
+		result = append(result, BlogPost{id, title, date, content})
+	}
+	return result, nil
+}
+
+func (repo PostgresBlogRepo) Init() error {
+	return migrate.Run(repo.db, migrate.Postgres, blogMigrationScope, postgresBlogMigrations)
+}
+
+func (repo PostgresBlogRepo) Get(id int64) (*BlogPost, error) {
+	var title string
+	var date time.Time
+	var content string
+	err := repo.db.QueryRow("SELECT title, date, content FROM blog WHERE id=$1", id).Scan(&title, &date, &content)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &BlogPost{id, title, date, content}, nil
+}
+
+func (repo PostgresBlogRepo) GetLatest() (*BlogPost, error) {
+	var id int64
+	var title string
+	var date time.Time
+	var content string
+	err := repo.db.QueryRow("SELECT id, title, date, content FROM blog ORDER BY date DESC LIMIT 1").Scan(&id, &title, &date, &content)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &BlogPost{id, title, date, content}, nil
+}
+
+func (repo PostgresBlogRepo) Set(blogPost BlogPost) error {
+	_, err := repo.db.Exec(
+		"UPDATE blog SET title=$1, date=$2, content=$3 WHERE id=$4",
+		blogPost.Title,
+		blogPost.Date.UTC(),
+		blogPost.Content,
+		blogPost.Id,
+	)
+	return err
+}
+func (repo PostgresBlogRepo) Create(title string, date time.Time, content string) (BlogPost, error) {
+	row := repo.db.QueryRow(
+		"INSERT INTO blog (title, date, content) VALUES ($1, $2, $3) RETURNING id",
+		title, date, content,
+	)
+
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return BlogPost{}, err
+	}
+
+	return BlogPost{id, title, date, content}, nil
+}
+
+// SQLiteBlogRepo is a BlogRepo backed by a local SQLite file.
+type SQLiteBlogRepo struct {
+	db *sql.DB
+}
+
+func NewSQLiteBlogRepo(db *sql.DB) *SQLiteBlogRepo {
+	r := &SQLiteBlogRepo{db}
+	check(r.Init())
+	return r
+}
+
+func (repo SQLiteBlogRepo) Init() error {
+	return migrate.Run(repo.db, migrate.SQLite, blogMigrationScope, sqliteBlogMigrations)
+}
+
+func (repo SQLiteBlogRepo) query(query string, args ...interface{}) ([]BlogPost, error) {
+	rows, err := repo.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []BlogPost{}
+
+	for rows.Next() {
+		var post BlogPost
+		if err := rows.Scan(&post.Id, &post.Title, &post.Date, &post.Content); err != nil {
+			return nil, err
+		}
+		result = append(result, post)
+	}
+	return result, rows.Err()
+}
+
+func (repo SQLiteBlogRepo) List() ([]BlogPost, error) {
+	return repo.query("SELECT id, title, date, content FROM blog")
+}
+
+func (repo SQLiteBlogRepo) Get(id int64) (*BlogPost, error) {
+	posts, err := repo.query("SELECT id, title, date, content FROM blog WHERE id=?", id)
+	if err != nil || len(posts) == 0 {
+		return nil, err
+	}
+	return &posts[0], nil
+}
+
+func (repo SQLiteBlogRepo) GetLatest() (*BlogPost, error) {
+	posts, err := repo.query("SELECT id, title, date, content FROM blog ORDER BY date DESC LIMIT 1")
+	if err != nil || len(posts) == 0 {
+		return nil, err
+	}
+	return &posts[0], nil
+}
+
+func (repo SQLiteBlogRepo) Set(blogPost BlogPost) error {
+	_, err := repo.db.Exec(
+		"UPDATE blog SET title=?, date=?, content=? WHERE id=?",
+		blogPost.Title,
+		blogPost.Date.UTC(),
+		blogPost.Content,
+		blogPost.Id,
+	)
+	return err
+}
+
+func (repo SQLiteBlogRepo) Create(title string, date time.Time, content string) (BlogPost, error) {
+	result, err := repo.db.Exec(
+		"INSERT INTO blog (title, date, content) VALUES (?, ?, ?)",
+		title, date.UTC(), content,
+	)
+	if err != nil {
+		return BlogPost{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return BlogPost{}, err
+	}
+
+	return BlogPost{id, title, date, content}, nil
+}
+
+// MemoryBlogRepo is a BlogRepo that keeps posts in memory.
+type MemoryBlogRepo struct {
+	mu    sync.Mutex
+	posts []BlogPost
+}
+
+func NewMemoryBlogRepo() *MemoryBlogRepo {
+	return &MemoryBlogRepo{}
+}
+
+func (repo *MemoryBlogRepo) List() ([]BlogPost, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return append([]BlogPost{}, repo.posts...), nil
+}
+
+func (repo *MemoryBlogRepo) Get(id int64) (*BlogPost, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, post := range repo.posts {
+		if post.Id == id {
+			return &post, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *MemoryBlogRepo) GetLatest() (*BlogPost, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var latest *BlogPost
+	for i, post := range repo.posts {
+		if latest == nil || post.Date.After(latest.Date) {
+			latest = &repo.posts[i]
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	post := *latest
+	return &post, nil
+}
+
+func (repo *MemoryBlogRepo) Set(blogPost BlogPost) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for i, post := range repo.posts {
+		if post.Id == blogPost.Id {
+			repo.posts[i] = blogPost
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryBlogRepo) Create(title string, date time.Time, content string) (BlogPost, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	post := BlogPost{int64(len(repo.posts) + 1), title, date, content}
+	repo.posts = append(repo.posts, post)
+	return post, nil
+}
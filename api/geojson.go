@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+// GeoJSON (RFC 7946) positions are [longitude, latitude], the reverse of
+// strava.LatLng, and a bbox is [west, south, east, north].
+
+type geoJSONGeometry struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Id         int64           `json:"id"`
+	Bbox       []float64       `json:"bbox"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties interface{}     `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Bbox     []float64        `json:"bbox,omitempty"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type routeProperties struct {
+	Name   string  `json:"name"`
+	Date   string  `json:"date"`
+	Type   string  `json:"type"`
+	Length float64 `json:"length"`
+}
+
+// routeFeature returns an activity's route as a LineString feature, or nil
+// if it has no route.
+func routeFeature(activity *strava.SummaryActivity) (*geoJSONFeature, error) {
+	points, err := activity.Route()
+	if err != nil || len(points) == 0 {
+		return nil, err
+	}
+	coordinates := make([][2]float64, len(points))
+	for i, p := range points {
+		coordinates[i] = [2]float64{p.Lng(), p.Lat()}
+	}
+	sw, ne := strava.RouteBounds(points)
+	return &geoJSONFeature{
+		Type:     "Feature",
+		Id:       activity.Id,
+		Bbox:     []float64{sw.Lng(), sw.Lat(), ne.Lng(), ne.Lat()},
+		Geometry: geoJSONGeometry{Type: "LineString", Coordinates: coordinates},
+		Properties: routeProperties{
+			Name:   activity.Name,
+			Date:   activity.Date().Format(time.RFC3339),
+			Type:   activity.Type,
+			Length: math.Round(strava.RouteLength(points)),
+		},
+	}, nil
+}
+
+// unionBbox returns the bbox around all features, or nil if there are none.
+func unionBbox(features []geoJSONFeature) []float64 {
+	if len(features) == 0 {
+		return nil
+	}
+	bbox := append([]float64{}, features[0].Bbox...)
+	for _, feature := range features[1:] {
+		bbox[0] = math.Min(bbox[0], feature.Bbox[0])
+		bbox[1] = math.Min(bbox[1], feature.Bbox[1])
+		bbox[2] = math.Max(bbox[2], feature.Bbox[2])
+		bbox[3] = math.Max(bbox[3], feature.Bbox[3])
+	}
+	return bbox
+}
@@ -7,7 +7,7 @@ require (
 	github.com/go-chi/chi/v5 v5.0.12
 	github.com/google/uuid v1.6.0
 	github.com/lib/pq v1.10.9
-	github.com/scottfrazer/running v0.0.0-20240303235818-27e437dfd1d7
+	github.com/mattn/go-sqlite3 v1.14.22
 	golang.org/x/crypto v0.20.0
 	golang.org/x/time v0.5.0
 )
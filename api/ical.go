@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// calendarEvent is a VEVENT in an iCalendar feed (RFC 5545).
+type calendarEvent struct {
+	Uid         string
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Url         string
+}
+
+const icalTimeFormat = "20060102T150405Z"
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// writeICalLine writes a content line, folding it at 75 octets as the spec
+// requires.  Folds never split a UTF-8 sequence.
+func writeICalLine(w io.Writer, line string) {
+	for len(line) > 75 {
+		cut := 75
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		fmt.Fprintf(w, "%s\r\n", line[:cut])
+		line = " " + line[cut:]
+	}
+	fmt.Fprintf(w, "%s\r\n", line)
+}
+
+func writeICal(w io.Writer, name string, events []calendarEvent) {
+	now := time.Now().UTC().Format(icalTimeFormat)
+	writeICalLine(w, "BEGIN:VCALENDAR")
+	writeICalLine(w, "VERSION:2.0")
+	writeICalLine(w, "PRODID:-//scottfrazer.net//website//EN")
+	writeICalLine(w, "CALSCALE:GREGORIAN")
+	writeICalLine(w, "X-WR-CALNAME:"+icalEscaper.Replace(name))
+	for _, event := range events {
+		writeICalLine(w, "BEGIN:VEVENT")
+		writeICalLine(w, "UID:"+event.Uid)
+		writeICalLine(w, "DTSTAMP:"+now)
+		writeICalLine(w, "DTSTART:"+event.Start.UTC().Format(icalTimeFormat))
+		writeICalLine(w, "DTEND:"+event.End.UTC().Format(icalTimeFormat))
+		writeICalLine(w, "SUMMARY:"+icalEscaper.Replace(event.Summary))
+		if event.Description != "" {
+			writeICalLine(w, "DESCRIPTION:"+icalEscaper.Replace(event.Description))
+		}
+		if event.Url != "" {
+			writeICalLine(w, "URL:"+event.Url)
+		}
+		writeICalLine(w, "END:VEVENT")
+	}
+	writeICalLine(w, "END:VCALENDAR")
+}
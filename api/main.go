@@ -4,18 +4,24 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
-	"github.com/scottfrazer/running/strava"
+	"github.com/scottfrazer/website/strava"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -28,98 +34,11 @@ func check(err error) {
 	}
 }
 
-type BlogRepo struct {
-	db *sql.DB
-}
-
-type BlogPost struct {
-	Id      int64     `json:"id"`
-	Title   string    `json:"title"`
-	Date    time.Time `json:"date"`
-	Content string    `json:"content"`
-}
-
-func NewBlogRepo(db *sql.DB) *BlogRepo {
-	r := &BlogRepo{db}
-	check(r.Init())
-	return r
-}
-
-func (repo BlogRepo) List() ([]BlogPost, error) {
-	rows, err := repo.db.Query("SELECT id, title, date, content FROM blog;")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	result := []BlogPost{}
-
-	for rows.Next() {
-		var id int64
-		var title string
-		var date time.Time
-		var content string
-		if err := rows.Scan(&id, &title, &date, &content); err != nil {
-			return nil, err
-		}
-		result = append(result, BlogPost{id, title, date, content})
-	}
-	return result, nil
-}
-
-func (repo BlogRepo) Init() error {
-	_, err := repo.db.Exec("CREATE TABLE IF NOT EXISTS blog (id bigserial, title text, date timestamp, content text)")
-	return err
-}
-
-func (repo BlogRepo) Get(id int64) (*BlogPost, error) {
-	var title string
-	var date time.Time
-	var content string
-	err := repo.db.QueryRow("SELECT title, date, content FROM blog WHERE id=$1", id).Scan(&title, &date, &content)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
-	}
-	return &BlogPost{id, title, date, content}, nil
-}
-
-func (repo BlogRepo) GetLatest() (*BlogPost, error) {
-	var id int64
-	var title string
-	var date time.Time
-	var content string
-	err := repo.db.QueryRow("SELECT id, title, date, content FROM blog ORDER BY date DESC LIMIT 1").Scan(&id, &title, &date, &content)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
-	}
-	return &BlogPost{id, title, date, content}, nil
-}
-
-func (repo BlogRepo) Set(blogPost BlogPost) error {
-	_, err := repo.db.Exec(
-		"UPDATE blog SET title=$1, date=$2, content=$3 WHERE id=$4",
-		blogPost.Title,
-		blogPost.Date.UTC(),
-		blogPost.Content,
-		blogPost.Id,
-	)
-	return err
-}
-func (repo BlogRepo) Create(title string, date time.Time, content string) (BlogPost, error) {
-	row := repo.db.QueryRow(
-		"INSERT INTO blog (title, date, content) VALUES ($1, $2, $3) RETURNING id",
-		title, date, content,
-	)
-
-	var id int64
-	if err := row.Scan(&id); err != nil {
-		return BlogPost{}, err
-	}
-
-	return BlogPost{id, title, date, content}, nil
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	b, err := json.Marshal(map[string]string{"error": message})
+	check(err)
+	w.Write(b)
 }
 
 var originAllowlist = []string{
@@ -179,14 +98,57 @@ func admin(next http.Handler) http.Handler {
 
 var tokens map[string]string
 
+// openStores picks the storage backend from $STORE: "postgres" (the default,
+// using $POSTGRES_DSN), "sqlite" (using $SQLITE_PATH) or "memory".
+func openStores() (strava.Store, BlogRepo) {
+	switch backend := os.Getenv("STORE"); backend {
+	case "", "postgres":
+		db, err := sql.Open("postgres", os.Getenv("POSTGRES_DSN"))
+		check(err)
+		store, err := strava.NewPostgresStore(os.Getenv("POSTGRES_DSN"))
+		check(err)
+		return store, NewPostgresBlogRepo(db)
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "website.db"
+		}
+		db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000", path))
+		check(err)
+		store, err := strava.NewSQLiteStore(path)
+		check(err)
+		return store, NewSQLiteBlogRepo(db)
+	case "memory":
+		return strava.NewMemoryStore(), NewMemoryBlogRepo()
+	default:
+		panic(fmt.Sprintf("unknown STORE: %s", backend))
+	}
+}
+
 func main() {
-	ctx := context.Background()
-	db, err := sql.Open("postgres", os.Getenv("POSTGRES_DSN"))
-	check(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	blogRepo := NewBlogRepo(db)
+	// Opening the stores applies any pending migrations, so "migrate" only
+	// has to open them, e.g. to migrate ahead of a deploy
+	store, blogRepo := openStores()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		log.Println("migrations are up to date")
+		return
+	}
 
 	tokens = map[string]string{}
+	routeSVGs := newRouteSVGCache()
+	// zoneSettings returns the stored zone settings, or the defaults if
+	// they've never been edited
+	zoneSettings := func() strava.ZoneSettings {
+		settings, err := store.GetZoneSettings()
+		check(err)
+		if settings == nil {
+			return strava.DefaultZoneSettings
+		}
+		return *settings
+	}
 
 	c := 0
 	s := time.Now()
@@ -198,20 +160,57 @@ func main() {
 	r.Use(cors)
 	r.Use(session)
 
-	store, err := strava.NewPostgresDataStore(os.Getenv("POSTGRES_DSN"))
-	check(err)
-	session, err := store.GetSession()
-	check(err)
-	if session == nil {
-		session = &strava.StravaSession{
-			RefreshToken: os.Getenv("STRAVA_REFRESH_TOKEN"),
-			ClientId:     os.Getenv("STRAVA_CLIENT_ID"),
-			ClientSecret: os.Getenv("STRAVA_SECRET_KEY"),
+	syncInterval := time.Hour
+	if v := os.Getenv("STRAVA_SYNC_INTERVAL"); v != "" {
+		var err error
+		syncInterval, err = time.ParseDuration(v)
+		check(err)
+	}
+	scheduler := strava.NewSyncScheduler(store, syncInterval, func() (*strava.StravaClient, error) {
+		session, err := store.GetSession()
+		if err != nil {
+			return nil, err
 		}
+		if session == nil {
+			session = &strava.StravaSession{
+				RefreshToken: os.Getenv("STRAVA_REFRESH_TOKEN"),
+				ClientId:     os.Getenv("STRAVA_CLIENT_ID"),
+				ClientSecret: os.Getenv("STRAVA_SECRET_KEY"),
+			}
+		}
+		return strava.NewStravaClientFromSession(*session, store)
+	})
+
+	// Webhook events are only accepted for our own subscription and athlete
+	var webhookSubscriptionId, athleteId int64
+	if v := os.Getenv("STRAVA_WEBHOOK_SUBSCRIPTION_ID"); v != "" {
+		var err error
+		webhookSubscriptionId, err = strconv.ParseInt(v, 10, 64)
+		check(err)
 	}
-	stravaClient, err := strava.NewStravaClientFromSession(*session)
-	check(err)
-	check(stravaClient.Sync(ctx, store))
+	if v := os.Getenv("STRAVA_ATHLETE_ID"); v != "" {
+		var err error
+		athleteId, err = strconv.ParseInt(v, 10, 64)
+		check(err)
+	}
+
+	heatmapDir := os.Getenv("HEATMAP_CACHE_DIR")
+	if heatmapDir == "" {
+		heatmapDir = filepath.Join(os.TempDir(), "website-heatmap")
+	}
+	heatmap := strava.NewHeatmap(store, heatmapDir)
+	scheduler.OnActivitiesChanged = func() {
+		if err := heatmap.Refresh(); err != nil {
+			log.Printf("refreshing heatmap: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scheduler.Run(ctx)
+	}()
 
 	r.Method("OPTIONS", "/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set headers for CORS preflight requests
@@ -223,21 +222,60 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 	}))
 	r.Get("/running/stats", func(w http.ResponseWriter, r *http.Request) {
-		all, err := store.Load(strava.ActivityFilter{})
+		query := r.URL.Query()
+		filters, err := activityFilterFromQuery(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		weekStart := time.Monday
+		if value := query.Get("weekStart"); value != "" {
+			weekStart, err = parseWeekday(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		minWeeklyRuns := 3
+		if value := query.Get("minWeeklyRuns"); value != "" {
+			minWeeklyRuns, err = strconv.Atoi(value)
+			if err != nil || minWeeklyRuns < 1 {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid minWeeklyRuns: %s", value))
+				return
+			}
+		}
+
+		totals, err := store.TotalsByYear(filters)
+		check(err)
+
+		// Streaks are of running days, and today is taken in the time zone
+		// of the most recent run
+		runs := filters
+		runs.Runs = true
+		days, err := store.TotalsByDay(runs)
+		check(err)
+		now := time.Now()
+		latest, err := store.LoadPage(runs, nil, 1)
 		check(err)
+		if len(latest) > 0 {
+			now = now.In(latest[0].Location())
+		}
 
 		type UiStats struct {
-			MilesPerYear     map[int]float64 `json:"miles_per_year"`
-			ActivitesPerYear map[int]int     `json:"activites_per_year"`
+			Total            int                `json:"total"`
+			MilesPerYear     map[int]float64    `json:"miles_per_year"`
+			ActivitesPerYear map[int]int        `json:"activites_per_year"`
+			Consistency      strava.Consistency `json:"consistency"`
 		}
 		stats := UiStats{
 			MilesPerYear:     map[int]float64{},
 			ActivitesPerYear: map[int]int{},
+			Consistency:      strava.ComputeConsistency(days, minWeeklyRuns, weekStart, now),
 		}
-		for _, activity := range all {
-			year := activity.Date().Year()
-			stats.MilesPerYear[year] += activity.Miles()
-			stats.ActivitesPerYear[year] += 1
+		for _, year := range totals {
+			stats.Total += year.Activities
+			stats.MilesPerYear[year.Year] = year.Distance / strava.MetersPerMile
+			stats.ActivitesPerYear[year.Year] = year.Activities
 		}
 
 		bytes, err := json.Marshal(stats)
@@ -245,21 +283,370 @@ func main() {
 		_, err = w.Write(bytes)
 		check(err)
 	})
-	r.Get("/running/list", func(w http.ResponseWriter, r *http.Request) {
+	r.Get("/running/aggregates", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filters, err := activityFilterFromQuery(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		weekStart := time.Monday
+		if value := query.Get("weekStart"); value != "" {
+			weekStart, err = parseWeekday(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		withZones := false
+		if value := query.Get("zones"); value != "" {
+			withZones, err = strconv.ParseBool(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid zones: %s", value))
+				return
+			}
+		}
+
+		days, err := store.TotalsByDay(filters)
+		check(err)
+
+		period := query.Get("period")
+		var periods []strava.PeriodTotals
+		switch period {
+		case "", "week":
+			periods = strava.WeeklyTotals(days, weekStart)
+		case "month":
+			periods = strava.MonthlyTotals(days)
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid period: %s", period))
+			return
+		}
+
+		// Time in zone needs every activity's streams or laps, so it's only
+		// worked out when asked for, and for at most a year at a time
+		var zones map[time.Time]*strava.ZoneDistribution
+		var settings strava.ZoneSettings
+		if withZones {
+			if len(days) > 0 && !days[len(days)-1].Date.Before(days[0].Date.AddDate(1, 0, 0)) {
+				writeError(w, http.StatusBadRequest, "zones are limited to a year of activities, narrow start and end")
+				return
+			}
+			settings = zoneSettings()
+			if period == "month" {
+				zones, err = strava.MonthlyZones(store, settings, filters)
+			} else {
+				zones, err = strava.WeeklyZones(store, settings, filters, weekStart)
+			}
+			check(err)
+		}
+
+		type UiPeriod struct {
+			strava.PeriodTotals
+			Miles      float64                  `json:"miles"`
+			MovingTime string                   `json:"moving_time_string"`
+			Pace       string                   `json:"pace"`
+			Zones      *strava.ZoneDistribution `json:"zones,omitempty"`
+		}
+		uiPeriods := []UiPeriod{}
+		for _, period := range periods {
+			uiPeriod := UiPeriod{
+				PeriodTotals: period,
+				Miles:        period.Miles(),
+				MovingTime:   period.MovingTimeString(),
+				Pace:         period.PacePerMile(),
+			}
+			if withZones {
+				uiPeriod.Zones = zones[period.Start]
+				if uiPeriod.Zones == nil {
+					uiPeriod.Zones = strava.NewZoneDistribution(settings)
+				}
+			}
+			uiPeriods = append(uiPeriods, uiPeriod)
+		}
+
+		bytes, err := json.Marshal(uiPeriods)
+		check(err)
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	r.Get("/running/load", func(w http.ResponseWriter, r *http.Request) {
 		query := r.URL.Query()
-		queryPage := query.Get("page")
-		queryPerPage := query.Get("perPage")
+		filters, err := activityFilterFromQuery(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Loads build up over weeks, so the series always starts from the
+		// first activity and is cut down to the requested range after
+		start := filters.Start
+		if filters.StartDate != nil {
+			start = filters.StartDate
+		}
+		filters.Start, filters.StartDate = nil, nil
+		end := time.Now()
+		if filters.End != nil {
+			end = filters.End.Add(-time.Nanosecond)
+		} else if filters.EndDate != nil {
+			end = filters.EndDate.Add(-time.Nanosecond)
+		}
+
+		activities, err := store.Load(filters)
+		check(err)
+		efforts, err := store.LoadRecordEfforts()
+		check(err)
+		opts := strava.EstimateThresholds(activities, efforts)
+		if value := query.Get("thresholdPace"); value != "" {
+			opts.ThresholdSpeed, err = parsePace(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if value := query.Get("thresholdHr"); value != "" {
+			opts.ThresholdHeartrate, err = strconv.ParseFloat(value, 64)
+			if err != nil || opts.ThresholdHeartrate <= 0 {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid thresholdHr: %s", value))
+				return
+			}
+		}
+
+		days := strava.TrainingLoad(activities, opts, end)
+		if start != nil {
+			from := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+			i := 0
+			for i < len(days) && days[i].Date.Before(from) {
+				i++
+			}
+			days = days[i:]
+		}
+
+		type UiTrainingLoad struct {
+			strava.TrainingLoadOptions
+			ThresholdPace string                   `json:"threshold_pace"`
+			Days          []strava.TrainingLoadDay `json:"days"`
+		}
+		load := UiTrainingLoad{
+			TrainingLoadOptions: opts,
+			ThresholdPace:       opts.ThresholdPacePerMile(),
+			Days:                days,
+		}
+
+		bytes, err := json.Marshal(load)
+		check(err)
+		_, err = w.Write(bytes)
+		check(err)
+	})
+
+	r.Get("/running/records", func(w http.ResponseWriter, r *http.Request) {
+		efforts, err := store.LoadRecordEfforts()
+		check(err)
+		progression := strava.RecordProgression(efforts)
+
+		type UiEffort struct {
+			strava.RecordEffort
+			ActivityName   string `json:"activity_name"`
+			Date           string `json:"date"`
+			TimeString     string `json:"time_string"`
+			DistanceString string `json:"distance_string"`
+			Pace           string `json:"pace"`
+		}
+		type UiRecord struct {
+			strava.RecordDistance
+			Current *UiEffort  `json:"current"`
+			History []UiEffort `json:"history"`
+		}
+
+		activities := map[int64]*strava.SummaryActivity{}
+		uiEffort := func(effort strava.RecordEffort) UiEffort {
+			activity, ok := activities[effort.ActivityId]
+			if !ok {
+				activity, err = store.LoadActivity(effort.ActivityId)
+				check(err)
+				activities[effort.ActivityId] = activity
+			}
+			e := UiEffort{
+				RecordEffort:   effort,
+				TimeString:     effort.TimeString(),
+				DistanceString: fmt.Sprintf("%.2f mi", effort.Distance/strava.MetersPerMile),
+				Pace:           effort.PacePerMile(),
+			}
+			if activity != nil {
+				e.ActivityName = activity.Name
+				e.Date = activity.StartLocal().Format(time.RFC3339)
+			}
+			return e
+		}
+
+		records := []UiRecord{}
+		distances := append(
+			append([]strava.RecordDistance{}, strava.RecordDistances...),
+			strava.RecordDistance{Key: strava.LongestRun, Name: "Longest Run"},
+		)
+		for _, distance := range distances {
+			record := UiRecord{RecordDistance: distance, History: []UiEffort{}}
+			for _, effort := range progression[distance.Key] {
+				record.History = append(record.History, uiEffort(effort))
+			}
+			if len(record.History) > 0 {
+				record.Current = &record.History[len(record.History)-1]
+			}
+			records = append(records, record)
+		}
+
+		bytes, err := json.Marshal(records)
+		check(err)
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	loadRaces := func() []strava.RaceResult {
+		race := true
+		activities, err := store.Load(strava.ActivityFilter{Race: &race})
+		check(err)
+		return strava.Races(activities)
+	}
+	r.Get("/running/races", func(w http.ResponseWriter, r *http.Request) {
+		category := r.URL.Query().Get("category")
 
-		page, _ := strconv.ParseInt(queryPage, 10, 64)
-		perPage, _ := strconv.ParseInt(queryPerPage, 10, 64)
+		type UiRaceEdition struct {
+			Id         int64  `json:"id"`
+			Date       string `json:"date"`
+			FinishTime string `json:"finish_time"`
+		}
+		type UiRace struct {
+			Id            int64          `json:"id"`
+			Name          string         `json:"name"`
+			Date          string         `json:"date"`
+			Category      string         `json:"category"`
+			Series        string         `json:"series"`
+			Distance      string         `json:"distance"`
+			FinishSeconds float64        `json:"finish_seconds"`
+			FinishTime    string         `json:"finish_time"`
+			Pace          string         `json:"pace"`
+			Previous      *UiRaceEdition `json:"previous"`
+			// DeltaSeconds is the change from the previous edition, negative
+			// when this one was faster
+			DeltaSeconds *float64 `json:"delta_seconds"`
+		}
 
-		if page == 0 {
-			page = 1
+		races := []UiRace{}
+		for _, result := range loadRaces() {
+			if category != "" && result.Category != category {
+				continue
+			}
+			activity := result.Activity
+			race := UiRace{
+				Id:            activity.Id,
+				Name:          activity.Name,
+				Date:          activity.StartLocal().Format(time.RFC3339),
+				Category:      result.Category,
+				Series:        result.Series,
+				Distance:      activity.DistanceString(),
+				FinishSeconds: activity.FinishTime(),
+				FinishTime:    activity.FinishTimeString(),
+				Pace:          activity.RacePacePerMile(),
+			}
+			if previous := result.Previous; previous != nil {
+				race.Previous = &UiRaceEdition{
+					Id:         previous.Id,
+					Date:       previous.StartLocal().Format(time.RFC3339),
+					FinishTime: previous.FinishTimeString(),
+				}
+				delta := activity.FinishTime() - previous.FinishTime()
+				race.DeltaSeconds = &delta
+			}
+			races = append(races, race)
+		}
+
+		bytes, err := json.Marshal(races)
+		check(err)
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	r.Get("/running/races.ics", func(w http.ResponseWriter, r *http.Request) {
+		events := []calendarEvent{}
+		for _, result := range loadRaces() {
+			activity := result.Activity
+			start := activity.StartUTC()
+			events = append(events, calendarEvent{
+				Uid:     fmt.Sprintf("strava-race-%d@scottfrazer.net", activity.Id),
+				Start:   start,
+				End:     start.Add(time.Duration(activity.FinishTime()) * time.Second),
+				Summary: fmt.Sprintf("%s (%s)", activity.Name, activity.FinishTimeString()),
+				Description: fmt.Sprintf(
+					"%s in %s, %s/mi",
+					activity.DistanceString(),
+					activity.FinishTimeString(),
+					activity.RacePacePerMile(),
+				),
+				Url: fmt.Sprintf("https://www.strava.com/activities/%d", activity.Id),
+			})
 		}
-		if perPage == 0 {
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		writeICal(w, "Races", events)
+	})
+	r.Get("/running/heatmap/{z}/{x}/{y}.png", func(w http.ResponseWriter, r *http.Request) {
+		var tile [3]int
+		for i, param := range []string{"z", "x", "y"} {
+			value, err := strconv.Atoi(chi.URLParam(r, param))
+			if err != nil || value < 0 {
+				writeError(w, http.StatusNotFound, "tile not found")
+				return
+			}
+			tile[i] = value
+		}
+
+		query := r.URL.Query()
+		filter := strava.HeatmapFilter{Type: query.Get("type")}
+		if value := query.Get("year"); value != "" {
+			year, err := strconv.Atoi(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid year: %s", value))
+				return
+			}
+			filter.Year = year
+		}
+
+		png, err := heatmap.Tile(tile[0], tile[1], tile[2], filter)
+		switch {
+		case errors.Is(err, strava.ErrInvalidTile):
+			writeError(w, http.StatusNotFound, "tile not found")
+			return
+		case errors.Is(err, strava.ErrInvalidHeatmapFilter):
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		check(err)
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		_, err = w.Write(png)
+		check(err)
+	})
+	r.Get("/running/list", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		perPage, _ := strconv.ParseInt(query.Get("perPage"), 10, 64)
+		if perPage <= 0 {
 			perPage = 50
 		}
 
+		var after *strava.ActivityCursor
+		if value := query.Get("cursor"); value != "" {
+			cursor, err := strava.DecodeActivityCursor(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			after = &cursor
+		}
+
+		filters, err := activityFilterFromQuery(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
 		type UiActivity struct {
 			Id          int64  `json:"id"`
 			Title       string `json:"title"`
@@ -269,13 +656,33 @@ func main() {
 			Type        string `json:"type"`
 			WorkoutType int    `json:"workout_type"`
 			Date        string `json:"date"`
+			Thumbnail   string `json:"thumbnail_url,omitempty"`
+		}
+
+		type UiActivityList struct {
+			Activities []UiActivity `json:"activities"`
+			Total      int          `json:"total"`
+			Next       string       `json:"next,omitempty"`
 		}
 
-		activities, err := store.LoadPage(int(page), int(perPage))
+		// Load one extra activity to find out whether there's a next page
+		activities, err := store.LoadPage(filters, after, int(perPage)+1)
 		check(err)
+		total, err := store.Count(filters)
+		check(err)
+
+		next := ""
+		if len(activities) > int(perPage) {
+			activities = activities[:perPage]
+			next = strava.CursorAfter(activities[len(activities)-1]).Encode()
+		}
 
 		uiActivities := []UiActivity{}
 		for _, activity := range activities {
+			thumbnail := ""
+			if activity.Map.Polyline != "" {
+				thumbnail = fmt.Sprintf("/running/activity/%d/route.svg", activity.Id)
+			}
 			uiActivities = append(uiActivities, UiActivity{
 				Id:          activity.Id,
 				Title:       activity.Name,
@@ -284,15 +691,363 @@ func main() {
 				Distance:    activity.DistanceString(),
 				Type:        activity.Type,
 				WorkoutType: activity.WorkoutType,
-				Date:        activity.Date().Format(time.RFC3339),
+				Date:        activity.StartLocal().Format(time.RFC3339),
+				Thumbnail:   thumbnail,
 			})
 		}
 
-		bytes, err := json.Marshal(uiActivities)
+		bytes, err := json.Marshal(UiActivityList{
+			Activities: uiActivities,
+			Total:      total,
+			Next:       next,
+		})
+		check(err)
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	r.Get("/running/activity/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		activity, err := store.LoadActivity(id)
+		check(err)
+		if activity == nil {
+			writeError(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		laps, err := store.LoadLaps(id)
+		check(err)
+		streams, err := store.LoadStreams(id)
+		check(err)
+
+		type UiLap struct {
+			strava.ActivityLap
+			DistanceString   string `json:"distance_string"`
+			MovingTimeString string `json:"moving_time_string"`
+			Pace             string `json:"pace"`
+			Elevation        string `json:"elevation"`
+			Cadence          string `json:"cadence"`
+		}
+		type UiActivityDetail struct {
+			strava.SummaryActivity
+			DistanceString   string                   `json:"distance_string"`
+			MovingTimeString string                   `json:"moving_time_string"`
+			Pace             string                   `json:"pace"`
+			Date             string                   `json:"date"`
+			Laps             []UiLap                  `json:"laps"`
+			Zones            *strava.ZoneDistribution `json:"zones"`
+		}
+
+		detail := UiActivityDetail{
+			SummaryActivity:  *activity,
+			DistanceString:   activity.DistanceString(),
+			MovingTimeString: activity.MovingTimeString(),
+			Pace:             activity.PacePerMile(),
+			Date:             activity.StartLocal().Format(time.RFC3339),
+			Laps:             []UiLap{},
+			Zones:            strava.ActivityZones(zoneSettings(), *activity, laps, streams),
+		}
+		for _, lap := range laps {
+			detail.Laps = append(detail.Laps, UiLap{
+				ActivityLap:      lap,
+				DistanceString:   lap.DistanceString(),
+				MovingTimeString: lap.MovingTimeString(),
+				Pace:             lap.PacePerMile(),
+				Elevation:        lap.ElevationString(),
+				Cadence:          lap.CadenceString(),
+			})
+		}
+
+		bytes, err := json.Marshal(detail)
+		check(err)
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	r.Get("/running/activity/{id}/route.geojson", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		activity, err := store.LoadActivity(id)
+		check(err)
+		if activity == nil {
+			writeError(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		feature, err := routeFeature(activity)
+		if err != nil {
+			// A bad polyline is served like a missing one
+			log.Printf("activity %d: %v", id, err)
+		}
+		if feature == nil {
+			writeError(w, http.StatusNotFound, "activity has no route")
+			return
+		}
+
+		bytes, err := json.Marshal(feature)
+		check(err)
+		w.Header().Set("Content-Type", "application/geo+json")
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	r.Get("/running/activity/{id}/route.svg", func(w http.ResponseWriter, r *http.Request) {
+		opts, err := routeSVGOptionsFromQuery(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		activity, err := store.LoadActivity(id)
+		check(err)
+		if activity == nil {
+			writeError(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		svg, err := routeSVGs.Get(activity, opts)
+		if err != nil {
+			// A bad polyline is served like a missing one
+			log.Printf("activity %d: %v", id, err)
+		}
+		if svg == nil {
+			writeError(w, http.StatusNotFound, "activity has no route")
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		_, err = w.Write(svg)
+		check(err)
+	})
+	r.Get("/running/activity/{id}/streams", func(w http.ResponseWriter, r *http.Request) {
+		points := 0
+		if value := r.URL.Query().Get("points"); value != "" {
+			var err error
+			points, err = strconv.Atoi(value)
+			if err != nil || points < 2 {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid points: %s", value))
+				return
+			}
+		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		activity, err := store.LoadActivity(id)
+		check(err)
+		if activity == nil {
+			writeError(w, http.StatusNotFound, "activity not found")
+			return
+		}
+
+		// Streams that weren't fetched during a sync are only fetched for an
+		// admin, so that anyone else can't spend the rate limits
+		streams, err := store.LoadStreams(id)
+		check(err)
+		if streams == nil {
+			if !isLoggedIn(r.Context()) {
+				writeError(w, http.StatusNotFound, "streams not fetched")
+				return
+			}
+			client, err := scheduler.Client()
+			if err != nil {
+				writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("connecting to strava: %v", err))
+				return
+			}
+			streams, err = client.Streams(r.Context(), store, id)
+			if err != nil {
+				writeError(w, http.StatusBadGateway, fmt.Sprintf("fetching streams: %v", err))
+				return
+			}
+		}
+
+		type UiStreams struct {
+			*strava.ActivityStreams
+			OriginalSize int `json:"original_size"`
+		}
+		uiStreams := UiStreams{ActivityStreams: streams, OriginalSize: streams.Len()}
+		if points > 0 {
+			uiStreams.ActivityStreams = streams.Downsample(points)
+		}
+
+		bytes, err := json.Marshal(uiStreams)
+		check(err)
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	r.Get("/running/routes.geojson", func(w http.ResponseWriter, r *http.Request) {
+		filters, err := activityFilterFromQuery(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		activities, err := store.Load(filters)
+		check(err)
+
+		collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+		for i := range activities {
+			feature, err := routeFeature(&activities[i])
+			if err != nil {
+				// One bad polyline shouldn't take down the whole map
+				log.Printf("activity %d: %v", activities[i].Id, err)
+				continue
+			}
+			if feature != nil {
+				collection.Features = append(collection.Features, *feature)
+			}
+		}
+		collection.Bbox = unionBbox(collection.Features)
+
+		bytes, err := json.Marshal(collection)
+		check(err)
+		w.Header().Set("Content-Type", "application/geo+json")
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	r.Get("/running/sync/status", func(w http.ResponseWriter, r *http.Request) {
+		lastSuccess, err := store.LastSuccessfulSyncRun("")
+		check(err)
+		recent, err := store.SyncRuns(20)
+		check(err)
+
+		type UiSyncStatus struct {
+			LastSynced  *time.Time       `json:"last_synced"`
+			LastSuccess *strava.SyncRun  `json:"last_success"`
+			Recent      []strava.SyncRun `json:"recent"`
+		}
+		status := UiSyncStatus{LastSuccess: lastSuccess, Recent: recent}
+		if lastSuccess != nil {
+			status.LastSynced = lastSuccess.FinishedAt
+		}
+
+		bytes, err := json.Marshal(status)
+		check(err)
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	r.Get("/running/zones", func(w http.ResponseWriter, r *http.Request) {
+		settings := zoneSettings()
+		type UiZones struct {
+			Settings strava.ZoneSettings      `json:"settings"`
+			Zones    *strava.ZoneDistribution `json:"zones"`
+		}
+		bytes, err := json.Marshal(UiZones{Settings: settings, Zones: strava.NewZoneDistribution(settings)})
+		check(err)
+		_, err = w.Write(bytes)
+		check(err)
+	})
+	r.With(admin).Post("/running/zones", func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		body, err := io.ReadAll(r.Body)
+		check(err)
+
+		// Fields that are left out keep their current values, except that
+		// switching heart rate basis without giving zones switches to that
+		// basis' default zones
+		settings := zoneSettings()
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid zone settings")
+			return
+		}
+		basis := settings.HeartrateBasis
+		if err := json.Unmarshal(body, &settings); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid zone settings")
+			return
+		}
+		if _, ok := fields["heartrate_zones"]; !ok && settings.HeartrateBasis != basis {
+			settings.HeartrateZones = strava.DefaultMaxHeartrateZones
+			if settings.HeartrateBasis == strava.HeartrateBasisThreshold {
+				settings.HeartrateZones = strava.DefaultThresholdHeartrateZones
+			}
+		}
+		if err := settings.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		check(store.SaveZoneSettings(settings))
+
+		bytes, err := json.Marshal(settings)
 		check(err)
 		_, err = w.Write(bytes)
 		check(err)
 	})
+	r.With(admin).Post("/running/sync", func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		body, err := io.ReadAll(r.Body)
+		check(err)
+		opts := strava.SyncOptions{Mode: strava.SyncIncremental}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &opts); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid sync options")
+				return
+			}
+		}
+		if err := opts.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !scheduler.Trigger(opts) {
+			writeError(w, http.StatusConflict, "a sync is already queued")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, `{"status": "queued"}`)
+	})
+	r.With(admin).Get("/running/sync/quota", func(w http.ResponseWriter, r *http.Request) {
+		client, err := scheduler.Client()
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("connecting to strava: %v", err))
+			return
+		}
+		b, err := json.Marshal(client.RateLimit())
+		check(err)
+		w.Write(b)
+	})
+	r.Get("/strava/webhook", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("hub.mode") != "subscribe" || query.Get("hub.verify_token") != os.Getenv("STRAVA_WEBHOOK_VERIFY_TOKEN") {
+			writeError(w, http.StatusForbidden, "invalid verify token")
+			return
+		}
+		b, err := json.Marshal(map[string]string{"hub.challenge": query.Get("hub.challenge")})
+		check(err)
+		w.Write(b)
+	})
+	r.Post("/strava/webhook", func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var event strava.WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid event")
+			return
+		}
+		if webhookSubscriptionId == 0 || athleteId == 0 {
+			writeError(w, http.StatusServiceUnavailable, "webhook is not configured")
+			return
+		}
+		if !event.IsFrom(webhookSubscriptionId, athleteId) {
+			writeError(w, http.StatusForbidden, "unknown subscription or athlete")
+			return
+		}
+
+		// Strava expects an acknowledgement within two seconds, so the
+		// activity is fetched after responding.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := scheduler.HandleEvent(ctx, event); err != nil {
+				log.Printf("strava webhook: %v", err)
+			}
+		}()
+		w.WriteHeader(http.StatusOK)
+	})
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		c++
 		b, err := json.Marshal(struct {
@@ -382,6 +1137,23 @@ func main() {
 		w.Write(listBytes)
 	})
 	port := "0.0.0.0:8080"
+	server := &http.Server{Addr: port, Handler: r}
+	idle := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("error: %v", err)
+		}
+		close(idle)
+	}()
+
 	fmt.Printf("listening on %s...\n", port)
-	http.ListenAndServe(port, r)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("error: %v", err)
+		stop()
+	}
+	<-idle
+	wg.Wait()
 }
@@ -0,0 +1,139 @@
+// Package migrate applies versioned schema migrations, recording the ones
+// that have run in a schema_migrations table.
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	SQLite
+)
+
+// Migration is one step of a schema's history.  Versions are applied in
+// increasing order and never re-applied, so a released migration must not be
+// edited, only followed by another.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+// Exec returns an Up that runs statements in order.
+func Exec(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Any arbitrary constant works, it only has to be the same for every process
+const postgresLockId = 7401
+
+func (d Dialect) placeholder(n int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+func createTable(db *sql.DB, dialect Dialect) error {
+	appliedAt := "timestamptz NOT NULL DEFAULT now()"
+	if dialect == SQLite {
+		appliedAt = "text NOT NULL DEFAULT CURRENT_TIMESTAMP"
+	}
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		scope text NOT NULL,
+		version integer NOT NULL,
+		name text NOT NULL,
+		applied_at %s,
+		PRIMARY KEY (scope, version)
+	)`, appliedAt))
+	return err
+}
+
+// Run applies the migrations of scope that haven't been applied yet, each in
+// its own transaction along with its schema_migrations row.  Scopes let
+// independent parts of the app keep their own version sequences in one
+// database.
+func Run(db *sql.DB, dialect Dialect, scope string, migrations []Migration) error {
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			return fmt.Errorf("%s migrations out of order at version %d", scope, migrations[i].Version)
+		}
+	}
+
+	if err := createTable(db, dialect); err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if err := apply(db, dialect, scope, migration); err != nil {
+			return fmt.Errorf("%s migration %d (%s): %w", scope, migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+func apply(db *sql.DB, dialect Dialect, scope string, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize with other processes migrating the same database, e.g. during
+	// a rolling deploy.  Checking the version after taking the lock means
+	// only one of them applies each migration.
+	if dialect == Postgres {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", postgresLockId); err != nil {
+			return err
+		}
+	}
+
+	var applied bool
+	err = tx.QueryRow(
+		fmt.Sprintf(
+			"SELECT count(*) > 0 FROM schema_migrations WHERE scope = %s AND version = %s",
+			dialect.placeholder(1),
+			dialect.placeholder(2),
+		),
+		scope,
+		migration.Version,
+	).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+
+	if err := migration.Up(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		fmt.Sprintf(
+			"INSERT INTO schema_migrations (scope, version, name) VALUES (%s, %s, %s)",
+			dialect.placeholder(1),
+			dialect.placeholder(2),
+			dialect.placeholder(3),
+		),
+		scope,
+		migration.Version,
+		migration.Name,
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("applied %s migration %d: %s", scope, migration.Version, migration.Name)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+// parseWeekday accepts a day name like "monday" or "Sun".
+func parseWeekday(value string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := day.String()
+		if strings.EqualFold(value, name) || strings.EqualFold(value, name[:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday: %s", value)
+}
+
+// parseQueryTime accepts either a date or an RFC3339 timestamp, reporting
+// which it was.  A date that ends a range is taken to include that whole day.
+func parseQueryTime(value string, end bool) (t time.Time, isDate bool, err error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+// activityFilterFromQuery reads an ActivityFilter from the query parameters
+// type, start, end, race, workoutType, minMiles, maxMiles and q.
+func activityFilterFromQuery(query url.Values) (strava.ActivityFilter, error) {
+	filters := strava.ActivityFilter{
+		Type: query.Get("type"),
+		Name: query.Get("q"),
+	}
+
+	// Dates are compared with the local date an activity started on, like
+	// the daily, weekly and monthly totals
+	if value := query.Get("start"); value != "" {
+		start, isDate, err := parseQueryTime(value, false)
+		if err != nil {
+			return filters, fmt.Errorf("invalid start: %s", value)
+		}
+		if isDate {
+			filters.StartDate = &start
+		} else {
+			filters.Start = &start
+		}
+	}
+	if value := query.Get("end"); value != "" {
+		end, isDate, err := parseQueryTime(value, true)
+		if err != nil {
+			return filters, fmt.Errorf("invalid end: %s", value)
+		}
+		if isDate {
+			filters.EndDate = &end
+		} else {
+			filters.End = &end
+		}
+	}
+	if value := query.Get("race"); value != "" {
+		race, err := strconv.ParseBool(value)
+		if err != nil {
+			return filters, fmt.Errorf("invalid race: %s", value)
+		}
+		filters.Race = &race
+	}
+	if value := query.Get("workoutType"); value != "" {
+		workoutType, err := strconv.Atoi(value)
+		if err != nil {
+			return filters, fmt.Errorf("invalid workoutType: %s", value)
+		}
+		filters.WorkoutType = &workoutType
+	}
+	if value := query.Get("minMiles"); value != "" {
+		miles, err := strconv.ParseFloat(value, 64)
+		if err != nil || miles < 0 {
+			return filters, fmt.Errorf("invalid minMiles: %s", value)
+		}
+		filters.MinDistance = miles * strava.MetersPerMile
+	}
+	if value := query.Get("maxMiles"); value != "" {
+		miles, err := strconv.ParseFloat(value, 64)
+		if err != nil || miles < 0 {
+			return filters, fmt.Errorf("invalid maxMiles: %s", value)
+		}
+		filters.MaxDistance = miles * strava.MetersPerMile
+	}
+	return filters, nil
+}
+
+// parsePace accepts a pace per mile like "7:30" and returns the speed in
+// meters per second.
+func parsePace(value string) (float64, error) {
+	minutes, seconds, ok := strings.Cut(value, ":")
+	m, err := strconv.Atoi(minutes)
+	if !ok || err != nil || m < 0 {
+		return 0, fmt.Errorf("invalid pace: %s", value)
+	}
+	s, err := strconv.Atoi(seconds)
+	if err != nil || s < 0 || s >= 60 || m*60+s == 0 {
+		return 0, fmt.Errorf("invalid pace: %s", value)
+	}
+	return strava.MetersPerMile / float64(m*60+s), nil
+}
@@ -0,0 +1,71 @@
+package strava
+
+import (
+	"fmt"
+	"time"
+)
+
+// PeriodTotals sums the activities in a week or month.  Start is the first
+// day of the period, as midnight UTC like DayTotals.Date.
+type PeriodTotals struct {
+	Start time.Time `json:"start"`
+	Label string    `json:"label"`
+	Totals
+}
+
+// WeeklyTotals buckets days into weeks beginning on weekStart.  Weeks are
+// labelled with the ISO week that most of their days fall in, which for
+// Monday weeks is exactly the ISO week.  Weeks without activities between
+// the first and last day are included, so the result is continuous.
+func WeeklyTotals(days []DayTotals, weekStart time.Weekday) []PeriodTotals {
+	return bucket(days, func(date time.Time) time.Time {
+		return weekOf(date, weekStart)
+	}, func(start time.Time) time.Time {
+		return start.AddDate(0, 0, 7)
+	}, func(start time.Time) string {
+		year, week := start.AddDate(0, 0, 3).ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+}
+
+// weekOf returns the first day of the week, beginning on weekStart, that
+// date is in.
+func weekOf(date time.Time, weekStart time.Weekday) time.Time {
+	offset := (int(date.Weekday()) - int(weekStart) + 7) % 7
+	return date.AddDate(0, 0, -offset)
+}
+
+// monthOf returns the first day of date's month.
+func monthOf(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// MonthlyTotals buckets days into calendar months, including months without
+// activities between the first and last day.
+func MonthlyTotals(days []DayTotals) []PeriodTotals {
+	return bucket(days, monthOf, func(start time.Time) time.Time {
+		return start.AddDate(0, 1, 0)
+	}, func(start time.Time) string {
+		return start.Format("2006-01")
+	})
+}
+
+// bucket groups days, which must be in order, into consecutive periods.
+// periodStart maps a day to the start of its period and next steps from one
+// period start to the following one.
+func bucket(days []DayTotals, periodStart func(time.Time) time.Time, next func(time.Time) time.Time, label func(time.Time) string) []PeriodTotals {
+	periods := []PeriodTotals{}
+	for _, day := range days {
+		start := periodStart(day.Date)
+		if len(periods) > 0 {
+			for last := periods[len(periods)-1].Start; last.Before(start); {
+				last = next(last)
+				periods = append(periods, PeriodTotals{Start: last, Label: label(last)})
+			}
+		} else {
+			periods = append(periods, PeriodTotals{Start: start, Label: label(start)})
+		}
+		periods[len(periods)-1].Add(day.Totals)
+	}
+	return periods
+}
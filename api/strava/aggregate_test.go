@@ -0,0 +1,59 @@
+package strava_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+func periodsString(periods []strava.PeriodTotals) string {
+	parts := []string{}
+	for _, period := range periods {
+		parts = append(parts, fmt.Sprintf("%s %s:%d", period.Start.Format("2006-01-02"), period.Label, period.Activities))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestWeeklyTotals(t *testing.T) {
+	days := []string{"2024-01-06*2", "2024-01-07", "2024-01-22"}
+	tests := []struct {
+		weekStart time.Weekday
+		days      []string
+		want      string
+	}{
+		{time.Monday, nil, ""},
+		{time.Monday, days, "2024-01-01 2024-W01:3, 2024-01-08 2024-W02:0, 2024-01-15 2024-W03:0, 2024-01-22 2024-W04:1"},
+		{time.Sunday, days, "2023-12-31 2024-W01:2, 2024-01-07 2024-W02:1, 2024-01-14 2024-W03:0, 2024-01-21 2024-W04:1"},
+		{time.Saturday, days, "2024-01-06 2024-W02:3, 2024-01-13 2024-W03:0, 2024-01-20 2024-W04:1"},
+		// The week of Dec 28 is the last ISO week of 2020
+		{time.Monday, []string{"2020-12-31", "2021-01-04"}, "2020-12-28 2020-W53:1, 2021-01-04 2021-W01:1"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %v", test.weekStart, test.days), func(t *testing.T) {
+			if got := periodsString(strava.WeeklyTotals(dayTotals(t, test.days...), test.weekStart)); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMonthlyTotals(t *testing.T) {
+	tests := []struct {
+		days []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"2024-01-01", "2024-01-31*2"}, "2024-01-01 2024-01:3"},
+		{[]string{"2023-12-31", "2024-03-01*2"}, "2023-12-01 2023-12:1, 2024-01-01 2024-01:0, 2024-02-01 2024-02:0, 2024-03-01 2024-03:2"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.days), func(t *testing.T) {
+			if got := periodsString(strava.MonthlyTotals(dayTotals(t, test.days...))); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package strava
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ActivityCursor is a position in the newest first ordering of activities,
+// by start time and then id.  Pages loaded after a cursor are unaffected by
+// activities added before it.
+type ActivityCursor struct {
+	Start time.Time
+	Id    int64
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorAfter returns the cursor that continues after activity.
+func CursorAfter(activity SummaryActivity) ActivityCursor {
+	return ActivityCursor{Start: activity.StartUTC(), Id: activity.Id}
+}
+
+// Encode returns the cursor as an opaque URL safe string.
+func (c ActivityCursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", c.Start.UnixNano(), c.Id)))
+}
+
+func DecodeActivityCursor(s string) (ActivityCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+	var nanos, id int64
+	if _, err := fmt.Sscanf(string(decoded), "%d.%d", &nanos, &id); err != nil {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+	return ActivityCursor{Start: time.Unix(0, nanos).UTC(), Id: id}, nil
+}
+
+// precedes reports whether activity comes after the cursor, i.e. is older.
+func (c ActivityCursor) precedes(activity SummaryActivity) bool {
+	start := activity.StartUTC()
+	return start.Before(c.Start) || (start.Equal(c.Start) && activity.Id < c.Id)
+}
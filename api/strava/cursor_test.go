@@ -0,0 +1,84 @@
+package strava_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/scottfrazer/website/strava"
+	"github.com/scottfrazer/website/strava/stravatest"
+)
+
+func TestActivityCursorRoundTrip(t *testing.T) {
+	tests := []strava.ActivityCursor{
+		{Start: time.Date(2024, 3, 1, 12, 30, 15, 0, time.UTC), Id: 123456789012},
+		{Start: time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC), Id: 1},
+		{Start: time.Unix(0, 0).UTC(), Id: 0},
+	}
+	for _, cursor := range tests {
+		t.Run(cursor.Encode(), func(t *testing.T) {
+			decoded, err := strava.DecodeActivityCursor(cursor.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !decoded.Start.Equal(cursor.Start) || decoded.Id != cursor.Id {
+				t.Errorf("got %+v, want %+v", decoded, cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeActivityCursorRejectsInvalid(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, value := range []string{"", "not base64!", encode("abc"), encode("12"), encode(".5")} {
+		t.Run(value, func(t *testing.T) {
+			if _, err := strava.DecodeActivityCursor(value); !errors.Is(err, strava.ErrInvalidCursor) {
+				t.Errorf("err = %v, want %v", err, strava.ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestLoadPageFollowsCursor(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store strava.Store) {
+		start := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+		// Activities 3 and 4 start at the same time, so ids break the tie
+		activities := []strava.SummaryActivity{
+			stravatest.Run(1, "Run 1", start, 3, 24*time.Minute),
+			stravatest.Run(2, "Run 2", start.AddDate(0, 0, 1), 3, 24*time.Minute),
+			stravatest.Run(3, "Run 3", start.AddDate(0, 0, 2), 3, 24*time.Minute),
+			stravatest.Run(4, "Run 4", start.AddDate(0, 0, 2), 3, 24*time.Minute),
+			stravatest.Run(5, "Run 5", start.AddDate(0, 0, 3), 3, 24*time.Minute),
+		}
+		if _, _, err := store.Save(activities); err != nil {
+			t.Fatal(err)
+		}
+
+		pages := []string{}
+		var after *strava.ActivityCursor
+		for len(pages) < 5 {
+			page, err := store.LoadPage(strava.ActivityFilter{}, after, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []int64{}
+			for _, activity := range page {
+				ids = append(ids, activity.Id)
+			}
+			pages = append(pages, fmt.Sprint(ids))
+			if len(page) == 0 {
+				break
+			}
+			cursor, err := strava.DecodeActivityCursor(strava.CursorAfter(page[len(page)-1]).Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			after = &cursor
+		}
+		if got, want := fmt.Sprint(pages), "[[5 4] [3 2] [1] []]"; got != want {
+			t.Errorf("pages = %s, want %s", got, want)
+		}
+	})
+}
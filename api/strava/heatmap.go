@@ -0,0 +1,407 @@
+package strava
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HeatmapTileSize is the width and height of a heatmap tile in pixels.
+const HeatmapTileSize = 256
+
+// HeatmapMaxZoom is the deepest zoom level tiles are rendered at.
+const HeatmapMaxZoom = 18
+
+// Routes through a pixel for full saturation
+const heatmapSaturation = 16
+
+var (
+	ErrInvalidTile          = errors.New("invalid tile")
+	ErrInvalidHeatmapFilter = errors.New("invalid heatmap filter")
+)
+
+// HeatmapFilter selects which activities go into a heatmap.
+type HeatmapFilter struct {
+	Year int
+	Type string
+}
+
+// key names the filter's directory in the tile cache.
+func (f HeatmapFilter) key() string {
+	year, activityType := "all", "all"
+	if f.Year != 0 {
+		year = strconv.Itoa(f.Year)
+	}
+	if f.Type != "" {
+		activityType = f.Type
+	}
+	return activityType + "-" + year
+}
+
+type heatmapRoute struct {
+	polyline     string
+	year         int
+	activityType string
+	points       []Point
+	min          Point
+	max          Point
+}
+
+func (r *heatmapRoute) matches(filter HeatmapFilter) bool {
+	return (filter.Year == 0 || r.year == filter.Year) && (filter.Type == "" || r.activityType == filter.Type)
+}
+
+// checkFilter rejects types and years without routes.  h.mu must be held.
+func (h *Heatmap) checkFilter(filter HeatmapFilter) error {
+	typeFound, yearFound := filter.Type == "", filter.Year == 0
+	for _, route := range h.routes {
+		typeFound = typeFound || route.activityType == filter.Type
+		yearFound = yearFound || route.year == filter.Year
+	}
+	if !typeFound {
+		return fmt.Errorf("%w: no %s routes", ErrInvalidHeatmapFilter, filter.Type)
+	}
+	if !yearFound {
+		return fmt.Errorf("%w: no routes in %d", ErrInvalidHeatmapFilter, filter.Year)
+	}
+	return nil
+}
+
+// Heatmap renders route density tiles and caches them on disk under dir.
+type Heatmap struct {
+	store Store
+	dir   string
+
+	mu     sync.RWMutex
+	routes map[int64]*heatmapRoute
+}
+
+func NewHeatmap(store Store, dir string) *Heatmap {
+	return &Heatmap{store: store, dir: dir}
+}
+
+func (h *Heatmap) loadRoutes() (map[int64]*heatmapRoute, error) {
+	activities, err := h.store.Load(ActivityFilter{})
+	if err != nil {
+		return nil, err
+	}
+	routes := map[int64]*heatmapRoute{}
+	for i := range activities {
+		activity := &activities[i]
+		points, err := activity.Route()
+		if err != nil {
+			log.Printf("strava: heatmap: activity %d: %v", activity.Id, err)
+			continue
+		}
+		if len(points) == 0 {
+			continue
+		}
+		route := &heatmapRoute{
+			polyline:     activity.Map.Polyline,
+			year:         activity.Date().Year(),
+			activityType: activity.Type,
+			points:       make([]Point, len(points)),
+		}
+		for j, p := range points {
+			route.points[j] = Mercator(p)
+		}
+		route.min, route.max = route.points[0], route.points[0]
+		for _, p := range route.points {
+			route.min = Point{math.Min(route.min.X, p.X), math.Min(route.min.Y, p.Y)}
+			route.max = Point{math.Max(route.max.X, p.X), math.Max(route.max.Y, p.Y)}
+		}
+		routes[activity.Id] = route
+	}
+	return routes, nil
+}
+
+// Refresh reloads the routes and invalidates the tiles that changed.
+func (h *Heatmap) Refresh() error {
+	routes, err := h.loadRoutes()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.routes == nil {
+		h.routes = routes
+		return h.invalidate(nil)
+	}
+
+	changed := []*heatmapRoute{}
+	for id, route := range routes {
+		if old, ok := h.routes[id]; !ok || old.polyline != route.polyline ||
+			old.year != route.year || old.activityType != route.activityType {
+			changed = append(changed, route)
+			if ok {
+				changed = append(changed, old)
+			}
+		}
+	}
+	for id, old := range h.routes {
+		if _, ok := routes[id]; !ok {
+			changed = append(changed, old)
+		}
+	}
+	h.routes = routes
+	if len(changed) == 0 {
+		return nil
+	}
+	return h.invalidate(changed)
+}
+
+// invalidate removes the tiles routes touch, or all if nil.  h.mu must be held.
+func (h *Heatmap) invalidate(routes []*heatmapRoute) error {
+	removed := 0
+	err := filepath.WalkDir(h.dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, ".png") {
+			return err
+		}
+		if routes != nil {
+			z, x, y, ok := parseTilePath(path)
+			if !ok || !tileTouches(z, x, y, routes) {
+				return nil
+			}
+		}
+		removed++
+		return os.Remove(path)
+	})
+	if removed > 0 {
+		log.Printf("strava: heatmap: invalidated %d tiles", removed)
+	}
+	return err
+}
+
+// parseTilePath reads z, x and y from a path ending in z/x/y.png.
+func parseTilePath(path string) (z, x, y int, ok bool) {
+	parts := strings.Split(filepath.ToSlash(strings.TrimSuffix(path, ".png")), "/")
+	if len(parts) < 3 {
+		return 0, 0, 0, false
+	}
+	values := [3]int{}
+	for i, part := range parts[len(parts)-3:] {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		values[i] = value
+	}
+	return values[0], values[1], values[2], true
+}
+
+// tileTouches reports whether any route's bounds overlap the tile.
+func tileTouches(z, x, y int, routes []*heatmapRoute) bool {
+	tiles := math.Exp2(float64(z))
+	margin := 1 / (tiles * HeatmapTileSize)
+	minX, minY := float64(x)/tiles-margin, float64(y)/tiles-margin
+	maxX, maxY := float64(x+1)/tiles+margin, float64(y+1)/tiles+margin
+	for _, route := range routes {
+		if route.max.X >= minX && route.min.X <= maxX && route.max.Y >= minY && route.min.Y <= maxY {
+			return true
+		}
+	}
+	return false
+}
+
+func validTile(z, x, y int) bool {
+	if z < 0 || z > HeatmapMaxZoom {
+		return false
+	}
+	tiles := 1 << z
+	return x >= 0 && x < tiles && y >= 0 && y < tiles
+}
+
+// Tile returns a PNG heatmap tile, rendering and caching it if needed.
+func (h *Heatmap) Tile(z, x, y int, filter HeatmapFilter) ([]byte, error) {
+	if !validTile(z, x, y) {
+		return nil, ErrInvalidTile
+	}
+
+	h.mu.Lock()
+	if h.routes == nil {
+		routes, err := h.loadRoutes()
+		if err != nil {
+			h.mu.Unlock()
+			return nil, err
+		}
+		h.routes = routes
+	}
+	h.mu.Unlock()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if err := h.checkFilter(filter); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(h.dir, filter.key(), strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".png")
+	if tile, err := os.ReadFile(path); err == nil {
+		return tile, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	tile, err := renderHeatmapTile(h.routes, z, x, y, filter)
+	if err != nil {
+		return nil, err
+	}
+	if tile == nil {
+		return blankHeatmapTile, nil
+	}
+	// The read lock keeps Refresh out until the tile is written
+	if err := writeFileAtomic(path, tile); err != nil {
+		return nil, err
+	}
+	return tile, nil
+}
+
+// writeFileAtomic writes through a temporary file and renames it.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// renderHeatmapTile returns nil if no matching route crosses the tile.
+func renderHeatmapTile(routes map[int64]*heatmapRoute, z, x, y int, filter HeatmapFilter) ([]byte, error) {
+	const size = HeatmapTileSize
+	scale := size * math.Exp2(float64(z))
+	originX, originY := float64(x*size), float64(y*size)
+
+	// last stops a route that crosses itself counting twice
+	counts := make([]int, size*size)
+	last := make([]int64, size*size)
+	for id, route := range routes {
+		if !route.matches(filter) || !tileTouches(z, x, y, []*heatmapRoute{route}) {
+			continue
+		}
+		stamp := id + 1
+		for i := 1; i < len(route.points); i++ {
+			a := Point{route.points[i-1].X*scale - originX, route.points[i-1].Y*scale - originY}
+			b := Point{route.points[i].X*scale - originX, route.points[i].Y*scale - originY}
+			a, b, ok := clipSegment(a, b, -1, size+1)
+			if !ok {
+				continue
+			}
+			steps := int(math.Ceil(math.Max(math.Abs(b.X-a.X), math.Abs(b.Y-a.Y))))
+			for s := 0; s <= steps; s++ {
+				t := 0.0
+				if steps > 0 {
+					t = float64(s) / float64(steps)
+				}
+				px := int(math.Floor(a.X + (b.X-a.X)*t))
+				py := int(math.Floor(a.Y + (b.Y-a.Y)*t))
+				if px < 0 || px >= size || py < 0 || py >= size {
+					continue
+				}
+				if pixel := py*size + px; last[pixel] != stamp {
+					last[pixel] = stamp
+					counts[pixel]++
+				}
+			}
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	empty := true
+	for pixel, count := range counts {
+		if count > 0 {
+			img.SetNRGBA(pixel%size, pixel/size, heatmapColor(count))
+			empty = false
+		}
+	}
+	if empty {
+		return nil, nil
+	}
+	return encodeHeatmapTile(img)
+}
+
+// blankHeatmapTile is served for tiles without routes.
+var blankHeatmapTile = func() []byte {
+	tile, err := encodeHeatmapTile(image.NewNRGBA(image.Rect(0, 0, HeatmapTileSize, HeatmapTileSize)))
+	if err != nil {
+		panic(err)
+	}
+	return tile
+}()
+
+func encodeHeatmapTile(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encoding heatmap tile: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// heatmapColor runs from translucent red to white on a log scale.
+func heatmapColor(count int) color.NRGBA {
+	t := math.Min(1, math.Log1p(float64(count))/math.Log1p(heatmapSaturation))
+	channel := func(start, end float64) uint8 {
+		return uint8(math.Round(start + (end-start)*t))
+	}
+	return color.NRGBA{
+		R: 255,
+		G: channel(40, 255),
+		B: uint8(math.Round(255 * math.Max(0, t*2-1))),
+		A: channel(110, 255),
+	}
+}
+
+// clipSegment clips a to b to the square [min, max] (Liang-Barsky).
+func clipSegment(a, b Point, min, max float64) (Point, Point, bool) {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t0, t1 := 0.0, 1.0
+	edges := [4][2]float64{
+		{-dx, a.X - min},
+		{dx, max - a.X},
+		{-dy, a.Y - min},
+		{dy, max - a.Y},
+	}
+	for _, edge := range edges {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+		if t0 > t1 {
+			return a, b, false
+		}
+	}
+	return Point{a.X + t0*dx, a.Y + t0*dy}, Point{a.X + t1*dx, a.Y + t1*dy}, true
+}
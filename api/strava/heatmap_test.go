@@ -0,0 +1,38 @@
+package strava_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+func TestHeatmapRejectsInvalidTiles(t *testing.T) {
+	heatmap := strava.NewHeatmap(strava.NewMemoryStore(), t.TempDir())
+	tests := []struct {
+		z, x, y int
+		valid   bool
+	}{
+		{0, 0, 0, true},
+		{1, 1, 1, true},
+		{strava.HeatmapMaxZoom, 1<<strava.HeatmapMaxZoom - 1, 0, true},
+		{-1, 0, 0, false},
+		{-64, 0, 0, false},
+		{strava.HeatmapMaxZoom + 1, 0, 0, false},
+		{0, -1, 0, false},
+		{0, 0, -1, false},
+		{0, 1, 0, false},
+		{0, 0, 1, false},
+		{2, 4, 0, false},
+		{2, 0, 4, false},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d/%d/%d", test.z, test.x, test.y), func(t *testing.T) {
+			_, err := heatmap.Tile(test.z, test.x, test.y, strava.HeatmapFilter{})
+			if invalid := errors.Is(err, strava.ErrInvalidTile); invalid == test.valid {
+				t.Errorf("Tile(%d, %d, %d) = %v, want valid %v", test.z, test.x, test.y, err, test.valid)
+			}
+		})
+	}
+}
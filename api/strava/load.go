@@ -0,0 +1,131 @@
+package strava
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// TrainingLoadOptions are the thresholds that an activity's intensity is
+// measured against.  ThresholdSpeed is in meters per second and is roughly
+// the pace that could be held for an hour, ThresholdHeartrate is the heart
+// rate at that effort.
+type TrainingLoadOptions struct {
+	ThresholdSpeed     float64 `json:"threshold_speed"`
+	ThresholdHeartrate float64 `json:"threshold_heartrate"`
+}
+
+// Time constants, in days, of the acute (fatigue) and chronic (fitness)
+// load averages
+const (
+	acuteLoadDays   = 7
+	chronicLoadDays = 42
+)
+
+// Used when an activity has no heart rate and isn't a run, so its speed says
+// little about how hard it was
+const defaultIntensity = 0.65
+
+// Used when there's nothing to estimate a threshold speed from, about 7:30
+// per mile
+const defaultThresholdSpeed = 3.58
+
+// EstimateThresholds guesses thresholds from an athlete's history.  The
+// threshold speed is the best one hour pace predicted by any current
+// record, using Riegel's formula, and the threshold heart rate is 90% of the
+// highest recorded heart rate.
+func EstimateThresholds(activities []SummaryActivity, efforts []RecordEffort) TrainingLoadOptions {
+	opts := TrainingLoadOptions{}
+	for record, history := range RecordProgression(efforts) {
+		if record == LongestRun || len(history) == 0 {
+			continue
+		}
+		best := history[len(history)-1]
+		if best.Time <= 0 {
+			continue
+		}
+		hourDistance := best.Distance * math.Pow(3600/best.Time, 1/1.06)
+		opts.ThresholdSpeed = math.Max(opts.ThresholdSpeed, hourDistance/3600)
+	}
+	if opts.ThresholdSpeed == 0 {
+		opts.ThresholdSpeed = defaultThresholdSpeed
+	}
+
+	maxHeartrate := 0.0
+	for _, activity := range activities {
+		maxHeartrate = math.Max(maxHeartrate, activity.MaxHeartrate)
+	}
+	opts.ThresholdHeartrate = maxHeartrate * 0.9
+	return opts
+}
+
+// ThresholdPacePerMile is ThresholdSpeed as a pace.
+func (o TrainingLoadOptions) ThresholdPacePerMile() string {
+	return formatPace(3600, metersToMiles(o.ThresholdSpeed*3600))
+}
+
+// Intensity is how hard an activity was relative to threshold, 1 being an
+// hour long race effort.  Heart rate is used when it was recorded.
+func (o TrainingLoadOptions) Intensity(activity SummaryActivity) float64 {
+	if activity.HasHeartrate && activity.AverageHeartrate > 0 && o.ThresholdHeartrate > 0 {
+		return activity.AverageHeartrate / o.ThresholdHeartrate
+	}
+	if isRun(activity) && activity.MovingTime > 0 && o.ThresholdSpeed > 0 {
+		return (activity.Distance / activity.MovingTime) / o.ThresholdSpeed
+	}
+	return defaultIntensity
+}
+
+// Stress scores an activity so that an hour at threshold is 100.
+func (o TrainingLoadOptions) Stress(activity SummaryActivity) float64 {
+	intensity := o.Intensity(activity)
+	return activity.MovingTime / 3600 * intensity * intensity * 100
+}
+
+// TrainingLoadDay is one day of the training load series.  AcuteLoad and
+// ChronicLoad are exponentially weighted averages of daily stress, and
+// Balance is the previous day's chronic minus acute load, i.e. the form
+// going into the day.
+type TrainingLoadDay struct {
+	Date        time.Time `json:"date"`
+	Stress      float64   `json:"stress"`
+	AcuteLoad   float64   `json:"acute_load"`
+	ChronicLoad float64   `json:"chronic_load"`
+	Balance     float64   `json:"balance"`
+}
+
+// TrainingLoad returns the daily series from the first activity through
+// end, by local date.  Dates are midnight UTC, like DayTotals.
+func TrainingLoad(activities []SummaryActivity, opts TrainingLoadOptions, end time.Time) []TrainingLoadDay {
+	stress := map[time.Time]float64{}
+	dates := []time.Time{}
+	for _, activity := range activities {
+		if activity.StartUTC().IsZero() {
+			continue
+		}
+		date := calendarDay(activity.StartLocal())
+		if _, ok := stress[date]; !ok {
+			dates = append(dates, date)
+		}
+		stress[date] += opts.Stress(activity)
+	}
+	if len(dates) == 0 {
+		return []TrainingLoadDay{}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	acuteDecay := math.Exp(-1.0 / acuteLoadDays)
+	chronicDecay := math.Exp(-1.0 / chronicLoadDays)
+	end = calendarDay(end)
+
+	days := []TrainingLoadDay{}
+	acute, chronic := 0.0, 0.0
+	for date := dates[0]; !date.After(end); date = date.AddDate(0, 0, 1) {
+		day := TrainingLoadDay{Date: date, Stress: stress[date], Balance: chronic - acute}
+		acute = acute*acuteDecay + day.Stress*(1-acuteDecay)
+		chronic = chronic*chronicDecay + day.Stress*(1-chronicDecay)
+		day.AcuteLoad, day.ChronicLoad = acute, chronic
+		days = append(days, day)
+	}
+	return days
+}
@@ -0,0 +1,483 @@
+package strava
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryRecord[T any] struct {
+	value     T
+	syncedAt  time.Time
+	updatedAt time.Time
+}
+
+// MemoryStore is a Store that keeps everything in memory, for running the
+// site locally and for tests.
+type MemoryStore struct {
+	mu          sync.Mutex
+	session     *StravaSession
+	activities  map[int64]*memoryRecord[SummaryActivity]
+	pending     map[int64]bool
+	laps        map[int64]map[int64]*memoryRecord[ActivityLap]
+	efforts     map[int64][]RecordEffort
+	streams     map[int64][]byte
+	zones       *ZoneSettings
+	checkpoints map[SyncMode]SyncCheckpoint
+	runs        []SyncRun
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		activities:  map[int64]*memoryRecord[SummaryActivity]{},
+		pending:     map[int64]bool{},
+		laps:        map[int64]map[int64]*memoryRecord[ActivityLap]{},
+		efforts:     map[int64][]RecordEffort{},
+		streams:     map[int64][]byte{},
+		checkpoints: map[SyncMode]SyncCheckpoint{},
+	}
+}
+
+func (s *MemoryStore) GetSession() (*StravaSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session == nil {
+		return nil, nil
+	}
+	session := *s.session
+	return &session, nil
+}
+
+func (s *MemoryStore) SaveSession(session *StravaSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := *session
+	s.session = &saved
+	return nil
+}
+
+func (s *MemoryStore) DeleteSession() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = nil
+	return nil
+}
+
+func (s *MemoryStore) GetMostRecentActivityDate() (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mostRecent := epoch
+	for _, record := range s.activities {
+		if date := record.value.StartUTC(); date.After(mostRecent) {
+			mostRecent = date
+		}
+	}
+	return mostRecent, nil
+}
+
+func (s *MemoryStore) Save(activities []SummaryActivity) (inserted []int64, updated []int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	inserted, updated = []int64{}, []int64{}
+	for _, activity := range activities {
+		if _, err := activity.ParseStart(); err != nil {
+			return nil, nil, err
+		}
+		record, ok := s.activities[activity.Id]
+		if !ok {
+			s.activities[activity.Id] = &memoryRecord[SummaryActivity]{activity, now, now}
+			s.pending[activity.Id] = true
+			inserted = append(inserted, activity.Id)
+			continue
+		}
+		record.syncedAt = now
+		if !reflect.DeepEqual(record.value, activity) {
+			if detailsChanged(record.value, activity) {
+				s.pending[activity.Id] = true
+			}
+			record.value = activity
+			record.updatedAt = now
+			updated = append(updated, activity.Id)
+		}
+	}
+	return inserted, updated, nil
+}
+
+func (s *MemoryStore) SaveLaps(activityId int64, laps []ActivityLap) (inserted int, updated int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	existing := s.laps[activityId]
+	saved := map[int64]*memoryRecord[ActivityLap]{}
+	for _, lap := range laps {
+		record, ok := existing[lap.Id]
+		if !ok {
+			record = &memoryRecord[ActivityLap]{lap, now, now}
+			inserted++
+		} else {
+			record.syncedAt = now
+			if !reflect.DeepEqual(record.value, lap) {
+				record.value = lap
+				record.updatedAt = now
+				updated++
+			}
+		}
+		saved[lap.Id] = record
+	}
+	s.laps[activityId] = saved
+	return inserted, updated, nil
+}
+
+func (s *MemoryStore) PendingDetails() ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []int64{}
+	for id := range s.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (s *MemoryStore) DetailsSaved(activityId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, activityId)
+	return nil
+}
+
+func (s *MemoryStore) Delete(activityId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.activities, activityId)
+	delete(s.pending, activityId)
+	delete(s.laps, activityId)
+	delete(s.efforts, activityId)
+	delete(s.streams, activityId)
+	return nil
+}
+
+// sorted returns activities matching filters, newest first, like the SQL
+// stores' ORDER BY start_date DESC, id DESC.
+func (s *MemoryStore) sorted(filters ActivityFilter) []SummaryActivity {
+	activities := []SummaryActivity{}
+	for _, record := range s.activities {
+		if filters.Matches(record.value) {
+			activities = append(activities, record.value)
+		}
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		a, b := activities[i].StartUTC(), activities[j].StartUTC()
+		if a.Equal(b) {
+			return activities[i].Id > activities[j].Id
+		}
+		return a.After(b)
+	})
+	return activities
+}
+
+func (s *MemoryStore) LoadPage(filters ActivityFilter, after *ActivityCursor, limit int) ([]SummaryActivity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page := []SummaryActivity{}
+	for _, activity := range s.sorted(filters) {
+		if len(page) == limit {
+			break
+		}
+		if after == nil || after.precedes(activity) {
+			page = append(page, activity)
+		}
+	}
+	return page, nil
+}
+
+func (s *MemoryStore) Load(filters ActivityFilter) ([]SummaryActivity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted(filters), nil
+}
+
+func (s *MemoryStore) Count(filters ActivityFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sorted(filters)), nil
+}
+
+func (s *MemoryStore) TotalsByYear(filters ActivityFilter) ([]YearTotals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byYear := map[int]*YearTotals{}
+	years := []int{}
+	for _, activity := range s.sorted(filters) {
+		year := activity.StartLocal().Year()
+		totals, ok := byYear[year]
+		if !ok {
+			totals = &YearTotals{Year: year}
+			byYear[year] = totals
+			years = append(years, year)
+		}
+		totals.addActivity(activity)
+	}
+	sort.Ints(years)
+	result := []YearTotals{}
+	for _, year := range years {
+		result = append(result, *byYear[year])
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) TotalsByDay(filters ActivityFilter) ([]DayTotals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byDate := map[time.Time]*DayTotals{}
+	dates := []time.Time{}
+	for _, activity := range s.sorted(filters) {
+		start := activity.StartLocal()
+		date := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		totals, ok := byDate[date]
+		if !ok {
+			totals = &DayTotals{Date: date}
+			byDate[date] = totals
+			dates = append(dates, date)
+		}
+		totals.addActivity(activity)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	result := []DayTotals{}
+	for _, date := range dates {
+		result = append(result, *byDate[date])
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) LoadActivity(activityId int64) (*SummaryActivity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.activities[activityId]
+	if !ok {
+		return nil, nil
+	}
+	activity := record.value
+	return &activity, nil
+}
+
+func (s *MemoryStore) LoadLaps(activityId int64) ([]ActivityLap, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	laps := []ActivityLap{}
+	for _, record := range s.laps[activityId] {
+		laps = append(laps, record.value)
+	}
+	sort.Slice(laps, func(i, j int) bool {
+		return laps[i].LapIndex < laps[j].LapIndex
+	})
+	return laps, nil
+}
+
+func (s *MemoryStore) LoadLapsMatching(filters ActivityFilter) (map[int64][]ActivityLap, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := map[int64][]ActivityLap{}
+	for _, activity := range s.sorted(filters) {
+		laps := []ActivityLap{}
+		for _, record := range s.laps[activity.Id] {
+			laps = append(laps, record.value)
+		}
+		if len(laps) == 0 {
+			continue
+		}
+		sort.Slice(laps, func(i, j int) bool {
+			return laps[i].LapIndex < laps[j].LapIndex
+		})
+		result[activity.Id] = laps
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) SaveRecordEfforts(activityId int64, efforts []RecordEffort) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.efforts[activityId] = append([]RecordEffort{}, efforts...)
+	return nil
+}
+
+func (s *MemoryStore) LoadRecordEfforts() ([]RecordEffort, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	efforts := []RecordEffort{}
+	for _, activityEfforts := range s.efforts {
+		efforts = append(efforts, activityEfforts...)
+	}
+	sort.SliceStable(efforts, func(i, j int) bool {
+		if !efforts[i].StartDate.Equal(efforts[j].StartDate) {
+			return efforts[i].StartDate.Before(efforts[j].StartDate)
+		}
+		return efforts[i].ActivityId < efforts[j].ActivityId
+	})
+	return efforts, nil
+}
+
+// Streams are kept encoded, as the SQL stores keep them, so that callers
+// never share slices with the store
+func (s *MemoryStore) SaveStreams(activityId int64, streams *ActivityStreams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[activityId] = streams.Encode()
+	return nil
+}
+
+func (s *MemoryStore) LoadStreams(activityId int64) (*ActivityStreams, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.streams[activityId]
+	if !ok {
+		return nil, nil
+	}
+	return DecodeStreams(value)
+}
+
+func (s *MemoryStore) LoadStreamsMatching(filters ActivityFilter) (map[int64]*ActivityStreams, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := map[int64]*ActivityStreams{}
+	for _, activity := range s.sorted(filters) {
+		value, ok := s.streams[activity.Id]
+		if !ok {
+			continue
+		}
+		streams, err := DecodeStreams(value)
+		if err != nil {
+			return nil, err
+		}
+		result[activity.Id] = streams
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) DeleteStreams(activityId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, activityId)
+	return nil
+}
+
+func (s *MemoryStore) GetZoneSettings() (*ZoneSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.zones == nil {
+		return nil, nil
+	}
+	settings := *s.zones
+	return &settings, nil
+}
+
+func (s *MemoryStore) SaveZoneSettings(settings ZoneSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zones = &settings
+	return nil
+}
+
+func (s *MemoryStore) GetSyncCheckpoint(mode SyncMode) (*SyncCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoint, ok := s.checkpoints[mode]
+	if !ok {
+		return nil, nil
+	}
+	return &checkpoint, nil
+}
+
+func (s *MemoryStore) StartSyncCheckpoint(mode SyncMode, after, before time.Time) (*SyncCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoint := SyncCheckpoint{After: after, Before: before, StartedAt: time.Now()}
+	s.checkpoints[mode] = checkpoint
+	return &checkpoint, nil
+}
+
+func (s *MemoryStore) SaveSyncCheckpoint(mode SyncMode, page int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if checkpoint, ok := s.checkpoints[mode]; ok {
+		checkpoint.Page = page
+		s.checkpoints[mode] = checkpoint
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClearSyncCheckpoint(mode SyncMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, mode)
+	return nil
+}
+
+func (s *MemoryStore) DeleteSyncedBefore(t time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stale := []int64{}
+	for id, record := range s.activities {
+		if record.syncedAt.Before(t) {
+			stale = append(stale, id)
+		}
+	}
+	// An empty listing is far more likely to be an API problem than every
+	// activity having been deleted
+	if len(stale) == len(s.activities) {
+		return 0, nil
+	}
+	for _, id := range stale {
+		delete(s.activities, id)
+		delete(s.pending, id)
+		delete(s.laps, id)
+		delete(s.efforts, id)
+		delete(s.streams, id)
+	}
+	return len(stale), nil
+}
+
+func (s *MemoryStore) StartSyncRun(mode SyncMode) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := int64(len(s.runs) + 1)
+	s.runs = append(s.runs, SyncRun{Id: id, Mode: mode, StartedAt: time.Now()})
+	return id, nil
+}
+
+func (s *MemoryStore) FinishSyncRun(id int64, stats SyncStats, syncErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run := &s.runs[id-1]
+	now := time.Now()
+	run.FinishedAt = &now
+	run.SyncStats = stats
+	if syncErr != nil {
+		run.Error = syncErr.Error()
+	}
+	return nil
+}
+
+func (s *MemoryStore) SyncRuns(limit int) ([]SyncRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := []SyncRun{}
+	for i := len(s.runs) - 1; i >= 0 && len(runs) < limit; i-- {
+		runs = append(runs, s.runs[i])
+	}
+	return runs, nil
+}
+
+func (s *MemoryStore) LastSuccessfulSyncRun(mode SyncMode) (*SyncRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		if run := s.runs[i]; run.FinishedAt != nil && run.Error == "" && (mode == "" || run.Mode == mode) {
+			return &run, nil
+		}
+	}
+	return nil, nil
+}
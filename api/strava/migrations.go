@@ -0,0 +1,289 @@
+package strava
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/scottfrazer/website/migrate"
+)
+
+// MigrationScope is the schema_migrations scope of the Strava tables.
+const MigrationScope = "strava"
+
+// The baselines use IF NOT EXISTS throughout so that databases created
+// before migrations were tracked pick them up without error.
+var postgresMigrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: migrate.Exec(
+			`CREATE TABLE IF NOT EXISTS strava_activities (
+				id bigint primary key,
+				start_date timestamptz,
+				value jsonb,
+				synced_at timestamptz,
+				updated_at timestamptz
+			)`,
+			// Older databases were created without these
+			`ALTER TABLE strava_activities ADD COLUMN IF NOT EXISTS start_date timestamptz`,
+			`ALTER TABLE strava_activities ADD COLUMN IF NOT EXISTS synced_at timestamptz`,
+			`ALTER TABLE strava_activities ADD COLUMN IF NOT EXISTS updated_at timestamptz`,
+
+			`CREATE TABLE IF NOT EXISTS strava_laps (
+				id bigint primary key,
+				activity_id text,
+				value jsonb,
+				synced_at timestamptz,
+				updated_at timestamptz
+			)`,
+			`ALTER TABLE strava_laps ADD COLUMN IF NOT EXISTS synced_at timestamptz`,
+			`ALTER TABLE strava_laps ADD COLUMN IF NOT EXISTS updated_at timestamptz`,
+
+			`CREATE TABLE IF NOT EXISTS strava_session (
+				id bigint primary key,
+				value jsonb
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS strava_sync_checkpoint (
+				mode text primary key,
+				after_time timestamptz,
+				before_time timestamptz,
+				page int,
+				started_at timestamptz
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS strava_sync_runs (
+				id bigserial primary key,
+				mode text,
+				started_at timestamptz,
+				finished_at timestamptz,
+				pages int,
+				activities_inserted int,
+				activities_updated int,
+				activities_deleted int,
+				laps_inserted int,
+				laps_updated int,
+				error text
+			)`,
+
+			`CREATE INDEX IF NOT EXISTS strava_activities_date ON strava_activities (start_date DESC)`,
+			`CREATE INDEX IF NOT EXISTS strava_laps_activity ON strava_laps (activity_id)`,
+		),
+	},
+	{
+		Version: 2,
+		Name:    "typed activity columns",
+		Up: migrate.Exec(
+			`ALTER TABLE strava_activities ADD COLUMN IF NOT EXISTS start_date_local timestamp`,
+			`ALTER TABLE strava_activities ADD COLUMN IF NOT EXISTS activity_type text`,
+			`ALTER TABLE strava_activities ADD COLUMN IF NOT EXISTS distance float8`,
+			`ALTER TABLE strava_activities ADD COLUMN IF NOT EXISTS moving_time float8`,
+			`ALTER TABLE strava_activities ADD COLUMN IF NOT EXISTS workout_type int`,
+			`ALTER TABLE strava_activities ADD COLUMN IF NOT EXISTS elevation_gain float8`,
+			`UPDATE strava_activities SET
+				start_date = coalesce(start_date, (value->>'start_date')::timestamptz),
+				start_date_local = (value->>'start_date_local')::timestamp,
+				activity_type = coalesce(value->>'type', ''),
+				distance = coalesce((value->>'distance')::float8, 0),
+				moving_time = coalesce((value->>'moving_time')::float8, 0),
+				workout_type = coalesce((value->>'workout_type')::int, 0),
+				elevation_gain = coalesce((value->>'total_elevation_gain')::float8, 0)
+			WHERE activity_type IS NULL`,
+			`CREATE INDEX IF NOT EXISTS strava_activities_type_date ON strava_activities (activity_type, start_date DESC)`,
+			`CREATE INDEX IF NOT EXISTS strava_activities_workout_type ON strava_activities (workout_type)`,
+			`CREATE INDEX IF NOT EXISTS strava_activities_date_local ON strava_activities (start_date_local)`,
+		),
+	},
+	{
+		Version: 3,
+		Name:    "record efforts",
+		Up: migrate.Exec(
+			`CREATE TABLE strava_record_efforts (
+				activity_id bigint,
+				record text,
+				source text,
+				distance float8,
+				seconds float8,
+				start_date timestamptz,
+				primary key (activity_id, record, source)
+			)`,
+			`CREATE INDEX strava_record_efforts_date ON strava_record_efforts (start_date, activity_id)`,
+		),
+	},
+	{
+		Version: 4,
+		Name:    "activity streams",
+		Up: migrate.Exec(
+			`CREATE TABLE strava_streams (
+				activity_id bigint primary key,
+				value bytea,
+				fetched_at timestamptz
+			)`,
+		),
+	},
+	{
+		Version: 5,
+		Name:    "zone settings",
+		Up: migrate.Exec(
+			`CREATE TABLE strava_zone_settings (
+				id bigint primary key,
+				value jsonb
+			)`,
+		),
+	},
+	{
+		Version: 6,
+		Name:    "activity details pending",
+		Up: migrate.Exec(
+			`ALTER TABLE strava_activities ADD COLUMN details_pending boolean NOT NULL DEFAULT false`,
+			`CREATE INDEX strava_activities_details_pending ON strava_activities (id) WHERE details_pending`,
+		),
+	},
+}
+
+var sqliteMigrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: migrate.Exec(
+			`CREATE TABLE IF NOT EXISTS strava_activities (
+				id integer primary key,
+				start_date text,
+				value text,
+				synced_at text,
+				updated_at text
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS strava_laps (
+				id integer primary key,
+				activity_id integer,
+				value text,
+				synced_at text,
+				updated_at text
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS strava_session (
+				id integer primary key,
+				value text
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS strava_sync_checkpoint (
+				mode text primary key,
+				after_time text,
+				before_time text,
+				page integer,
+				started_at text
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS strava_sync_runs (
+				id integer primary key autoincrement,
+				mode text,
+				started_at text,
+				finished_at text,
+				pages integer,
+				activities_inserted integer,
+				activities_updated integer,
+				activities_deleted integer,
+				laps_inserted integer,
+				laps_updated integer,
+				error text
+			)`,
+
+			`CREATE INDEX IF NOT EXISTS strava_activities_date ON strava_activities (start_date DESC)`,
+			`CREATE INDEX IF NOT EXISTS strava_laps_activity ON strava_laps (activity_id)`,
+		),
+	},
+	{
+		Version: 2,
+		Name:    "typed activity columns",
+		Up: func(tx *sql.Tx) error {
+			columns := [][2]string{
+				{"start_date_local", "text"},
+				{"activity_type", "text"},
+				{"distance", "real"},
+				{"moving_time", "real"},
+				{"workout_type", "integer"},
+				{"elevation_gain", "real"},
+			}
+			for _, column := range columns {
+				if err := sqliteAddColumn(tx, "strava_activities", column[0], column[1]); err != nil {
+					return err
+				}
+			}
+			return migrate.Exec(
+				`UPDATE strava_activities SET
+					start_date_local = substr(json_extract(value, '$.start_date_local'), 1, 19),
+					activity_type = coalesce(json_extract(value, '$.type'), ''),
+					distance = coalesce(json_extract(value, '$.distance'), 0),
+					moving_time = coalesce(json_extract(value, '$.moving_time'), 0),
+					workout_type = coalesce(json_extract(value, '$.workout_type'), 0),
+					elevation_gain = coalesce(json_extract(value, '$.total_elevation_gain'), 0)
+				WHERE activity_type IS NULL`,
+				`CREATE INDEX IF NOT EXISTS strava_activities_type_date ON strava_activities (activity_type, start_date DESC)`,
+				`CREATE INDEX IF NOT EXISTS strava_activities_workout_type ON strava_activities (workout_type)`,
+				`CREATE INDEX IF NOT EXISTS strava_activities_date_local ON strava_activities (start_date_local)`,
+			)(tx)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "record efforts",
+		Up: migrate.Exec(
+			`CREATE TABLE strava_record_efforts (
+				activity_id integer,
+				record text,
+				source text,
+				distance real,
+				seconds real,
+				start_date text,
+				primary key (activity_id, record, source)
+			)`,
+			`CREATE INDEX strava_record_efforts_date ON strava_record_efforts (start_date, activity_id)`,
+		),
+	},
+	{
+		Version: 4,
+		Name:    "activity streams",
+		Up: migrate.Exec(
+			`CREATE TABLE strava_streams (
+				activity_id integer primary key,
+				value blob,
+				fetched_at text
+			)`,
+		),
+	},
+	{
+		Version: 5,
+		Name:    "zone settings",
+		Up: migrate.Exec(
+			`CREATE TABLE strava_zone_settings (
+				id integer primary key,
+				value text
+			)`,
+		),
+	},
+	{
+		Version: 6,
+		Name:    "activity details pending",
+		Up: migrate.Exec(
+			`ALTER TABLE strava_activities ADD COLUMN details_pending integer NOT NULL DEFAULT 0`,
+			`CREATE INDEX strava_activities_details_pending ON strava_activities (id) WHERE details_pending`,
+		),
+	},
+}
+
+// sqliteAddColumn adds a column to table unless it already has it, since
+// SQLite has no ADD COLUMN IF NOT EXISTS.
+func sqliteAddColumn(tx *sql.Tx, table, column, columnType string) error {
+	var exists bool
+	err := tx.QueryRow(
+		"SELECT count(*) > 0 FROM pragma_table_info(?) WHERE name = ?",
+		table,
+		column,
+	).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType))
+	return err
+}
@@ -0,0 +1,90 @@
+package strava
+
+import (
+	"errors"
+	"math"
+)
+
+// LatLng is a point as [latitude, longitude] in degrees, the order Strava
+// uses.
+type LatLng [2]float64
+
+func (p LatLng) Lat() float64 { return p[0] }
+func (p LatLng) Lng() float64 { return p[1] }
+
+var ErrInvalidPolyline = errors.New("invalid polyline")
+
+// DecodePolyline decodes a route in Google's encoded polyline format, which
+// is what Strava's map polylines are, at the usual precision of 5 decimal
+// places.
+func DecodePolyline(encoded string) ([]LatLng, error) {
+	points := []LatLng{}
+	lat, lng := 0, 0
+	for i := 0; i < len(encoded); {
+		var deltas [2]int
+		for j := range deltas {
+			result, shift := 0, 0
+			for {
+				if i >= len(encoded) || shift > 30 {
+					return nil, ErrInvalidPolyline
+				}
+				b := int(encoded[i]) - 63
+				i++
+				if b < 0 || b > 63 {
+					return nil, ErrInvalidPolyline
+				}
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				deltas[j] = ^(result >> 1)
+			} else {
+				deltas[j] = result >> 1
+			}
+		}
+		lat += deltas[0]
+		lng += deltas[1]
+		points = append(points, LatLng{float64(lat) / 1e5, float64(lng) / 1e5})
+	}
+	return points, nil
+}
+
+// Route decodes the activity's summary polyline.  Activities without GPS
+// have an empty route.
+func (a *SummaryActivity) Route() ([]LatLng, error) {
+	return DecodePolyline(a.Map.Polyline)
+}
+
+const earthRadius = 6371008.8
+
+// Distance is the great circle distance to q in meters.
+func (p LatLng) Distance(q LatLng) float64 {
+	lat1, lat2 := p.Lat()*math.Pi/180, q.Lat()*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (q.Lng() - p.Lng()) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// RouteLength is the length of a route in meters.
+func RouteLength(points []LatLng) float64 {
+	length := 0.0
+	for i := 1; i < len(points); i++ {
+		length += points[i-1].Distance(points[i])
+	}
+	return length
+}
+
+// RouteBounds returns the south west and north east corners of the box
+// around a route, which must not be empty.
+func RouteBounds(points []LatLng) (LatLng, LatLng) {
+	sw, ne := points[0], points[0]
+	for _, p := range points[1:] {
+		sw = LatLng{math.Min(sw.Lat(), p.Lat()), math.Min(sw.Lng(), p.Lng())}
+		ne = LatLng{math.Max(ne.Lat(), p.Lat()), math.Max(ne.Lng(), p.Lng())}
+	}
+	return sw, ne
+}
@@ -0,0 +1,50 @@
+package strava_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+func TestDecodePolyline(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    []strava.LatLng
+		err     error
+	}{
+		{"empty", "", []strava.LatLng{}, nil},
+		// The example from Google's description of the format
+		{"example", "_p~iF~ps|U_ulLnnqC_mqNvxq`@", []strava.LatLng{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}, nil},
+		{"single point", "??", []strava.LatLng{{0, 0}}, nil},
+		{"missing longitude", "_p~iF", nil, strava.ErrInvalidPolyline},
+		{"truncated chunk", "_p~iF~ps|", nil, strava.ErrInvalidPolyline},
+		{"invalid character", "_p~iF ps|U", nil, strava.ErrInvalidPolyline},
+		{"too long", "~~~~~~~~~~??", nil, strava.ErrInvalidPolyline},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := strava.DecodePolyline(test.encoded)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRouteLength(t *testing.T) {
+	// A degree of latitude is about 111.2km
+	points := []strava.LatLng{{0, 0}, {1, 0}, {1, 0}, {2, 0}}
+	if got := strava.RouteLength(points); math.Abs(got-222390) > 10 {
+		t.Errorf("RouteLength = %v, want about 222390", got)
+	}
+	if got := strava.RouteLength(points[:1]); got != 0 {
+		t.Errorf("RouteLength of one point = %v, want 0", got)
+	}
+}
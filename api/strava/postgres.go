@@ -0,0 +1,753 @@
+package strava
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/scottfrazer/website/migrate"
+)
+
+// PostgresStore is a Store backed by Postgres, keeping each activity and
+// lap as a JSONB document, with the activity fields that are filtered and
+// aggregated on copied into columns.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate.Run(db, migrate.Postgres, MigrationScope, postgresMigrations); err != nil {
+		return nil, err
+	}
+
+	return &PostgresStore{db}, nil
+}
+
+func (s *PostgresStore) GetMostRecentActivityDate() (time.Time, error) {
+	query := `SELECT coalesce(max(start_date), '1970-01-01T00:00:00Z'::timestamptz) FROM strava_activities`
+	var t time.Time
+	err := s.db.QueryRow(query).Scan(&t)
+	return t, err
+}
+
+func (s *PostgresStore) GetSession() (*StravaSession, error) {
+	var bytes []byte
+	err := s.db.QueryRow("SELECT value FROM strava_session WHERE id=1").Scan(&bytes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var session StravaSession
+	if err := json.Unmarshal(bytes, &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (s *PostgresStore) SaveSession(session *StravaSession) error {
+	bytes, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO strava_session (id, value)
+		VALUES (1, $1)
+		ON CONFLICT (id)
+		DO UPDATE SET value = EXCLUDED.value`
+	if _, err := s.db.Exec(query, bytes); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Save upserts activities, returning the ids of those that were inserted and
+// of existing ones whose content changed.  synced_at is bumped for every row,
+// updated_at only when the row changed, so the two are equal exactly when it
+// changed.  xmax is zero only for freshly inserted rows.
+func (s *PostgresStore) Save(activities []SummaryActivity) (inserted []int64, updated []int64, err error) {
+	query := `INSERT INTO strava_activities (
+			id, start_date, start_date_local, activity_type, distance, moving_time, workout_type, elevation_gain,
+			value, synced_at, updated_at, details_pending
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, now(), now(), true)
+		ON CONFLICT (id)
+		DO UPDATE SET
+			start_date = EXCLUDED.start_date,
+			start_date_local = EXCLUDED.start_date_local,
+			activity_type = EXCLUDED.activity_type,
+			distance = EXCLUDED.distance,
+			moving_time = EXCLUDED.moving_time,
+			workout_type = EXCLUDED.workout_type,
+			elevation_gain = EXCLUDED.elevation_gain,
+			value = EXCLUDED.value,
+			synced_at = EXCLUDED.synced_at,
+			updated_at = CASE
+				WHEN strava_activities.value IS DISTINCT FROM EXCLUDED.value THEN EXCLUDED.updated_at
+				ELSE strava_activities.updated_at
+			END,
+			-- Like detailsChanged
+			details_pending = strava_activities.details_pending OR (
+				strava_activities.value->'name', strava_activities.value->'type',
+				strava_activities.value->'start_date_local', strava_activities.value->'distance',
+				strava_activities.value->'moving_time', strava_activities.value->'workout_type',
+				strava_activities.value->'map'->'summary_polyline'
+			) IS DISTINCT FROM (
+				EXCLUDED.value->'name', EXCLUDED.value->'type',
+				EXCLUDED.value->'start_date_local', EXCLUDED.value->'distance',
+				EXCLUDED.value->'moving_time', EXCLUDED.value->'workout_type',
+				EXCLUDED.value->'map'->'summary_polyline'
+			)
+		RETURNING xmax = 0, updated_at = synced_at`
+	inserted, updated = []int64{}, []int64{}
+	for _, activity := range activities {
+		start, err := activity.ParseStart()
+		if err != nil {
+			return nil, nil, err
+		}
+		serialized, err := json.Marshal(activity)
+		if err != nil {
+			return nil, nil, err
+		}
+		var isInserted, isChanged bool
+		err = s.db.QueryRow(
+			query,
+			activity.Id,
+			start,
+			activity.StartLocal().Format(localTimeFormat),
+			activity.Type,
+			activity.Distance,
+			activity.MovingTime,
+			activity.WorkoutType,
+			activity.ElevationGain,
+			serialized,
+		).Scan(&isInserted, &isChanged)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isInserted {
+			inserted = append(inserted, activity.Id)
+		} else if isChanged {
+			updated = append(updated, activity.Id)
+		}
+	}
+	return inserted, updated, nil
+}
+
+// SaveLaps upserts the laps of an activity and removes any stored laps that
+// are no longer part of it, returning how many were inserted and changed.
+func (s *PostgresStore) SaveLaps(activityId int64, laps []ActivityLap) (inserted int, updated int, err error) {
+	query := `INSERT INTO strava_laps (id, activity_id, value, synced_at, updated_at)
+		VALUES ($1, $2, $3, now(), now())
+		ON CONFLICT (id)
+		DO UPDATE SET
+			activity_id = EXCLUDED.activity_id,
+			value = EXCLUDED.value,
+			synced_at = EXCLUDED.synced_at,
+			updated_at = CASE
+				WHEN strava_laps.value IS DISTINCT FROM EXCLUDED.value THEN EXCLUDED.updated_at
+				ELSE strava_laps.updated_at
+			END
+		RETURNING xmax = 0, updated_at = synced_at`
+	ids := []int64{}
+	for _, lap := range laps {
+		serialized, err := json.Marshal(lap)
+		if err != nil {
+			return 0, 0, err
+		}
+		var isInserted, isChanged bool
+		if err := s.db.QueryRow(query, lap.Id, activityId, serialized).Scan(&isInserted, &isChanged); err != nil {
+			return 0, 0, err
+		}
+		if isInserted {
+			inserted++
+		} else if isChanged {
+			updated++
+		}
+		ids = append(ids, lap.Id)
+	}
+
+	_, err = s.db.Exec(
+		"DELETE FROM strava_laps WHERE activity_id=$1 AND NOT (id = ANY($2))",
+		strconv.FormatInt(activityId, 10),
+		pq.Array(ids),
+	)
+	return inserted, updated, err
+}
+
+func (s *PostgresStore) Delete(activityId int64) error {
+	if _, err := s.db.Exec("DELETE FROM strava_streams WHERE activity_id=$1", activityId); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM strava_record_efforts WHERE activity_id=$1", activityId); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM strava_laps WHERE activity_id=$1", strconv.FormatInt(activityId, 10)); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM strava_activities WHERE id=$1", activityId)
+	return err
+}
+
+func (s *PostgresStore) PendingDetails() ([]int64, error) {
+	rows, err := s.db.Query("SELECT id FROM strava_activities WHERE details_pending ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *PostgresStore) DetailsSaved(activityId int64) error {
+	_, err := s.db.Exec("UPDATE strava_activities SET details_pending = false WHERE id=$1", activityId)
+	return err
+}
+
+func (s *PostgresStore) DeleteSession() error {
+	_, err := s.db.Exec("DELETE FROM strava_session WHERE id=1")
+	return err
+}
+
+func (s *PostgresStore) activityQuery(query string, args ...interface{}) ([]SummaryActivity, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := []SummaryActivity{}
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		var activity SummaryActivity
+		if err := json.Unmarshal(value, &activity); err != nil {
+			return nil, err
+		}
+		activities = append(activities, activity)
+	}
+	return activities, nil
+}
+
+func (s *PostgresStore) LoadActivity(activityId int64) (*SummaryActivity, error) {
+	var value []byte
+	err := s.db.QueryRow("SELECT value FROM strava_activities WHERE id=$1", activityId).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var activity SummaryActivity
+	if err := json.Unmarshal(value, &activity); err != nil {
+		return nil, err
+	}
+	return &activity, nil
+}
+
+func (s *PostgresStore) LoadLaps(activityId int64) ([]ActivityLap, error) {
+	rows, err := s.db.Query(
+		"SELECT value FROM strava_laps WHERE activity_id=$1 ORDER BY (value->>'lap_index')::int",
+		strconv.FormatInt(activityId, 10),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	laps := []ActivityLap{}
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		var lap ActivityLap
+		if err := json.Unmarshal(value, &lap); err != nil {
+			return nil, err
+		}
+		laps = append(laps, lap)
+	}
+	return laps, rows.Err()
+}
+
+func (s *PostgresStore) LoadLapsMatching(filters ActivityFilter) (map[int64][]ActivityLap, error) {
+	where, args := s.filterClause(filters)
+	rows, err := s.db.Query(`
+		SELECT activity_id::bigint, value FROM strava_laps
+		WHERE activity_id IN (SELECT id::text FROM strava_activities`+where+`)
+		ORDER BY activity_id, (value->>'lap_index')::int`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	laps := map[int64][]ActivityLap{}
+	for rows.Next() {
+		var activityId int64
+		var value []byte
+		if err := rows.Scan(&activityId, &value); err != nil {
+			return nil, err
+		}
+		var lap ActivityLap
+		if err := json.Unmarshal(value, &lap); err != nil {
+			return nil, err
+		}
+		laps[activityId] = append(laps[activityId], lap)
+	}
+	return laps, rows.Err()
+}
+
+// filterClause returns the WHERE clause, if any, and its arguments for
+// filters.  Placeholders are numbered from $1.
+func (s *PostgresStore) filterClause(filters ActivityFilter) (string, []interface{}) {
+	where := []string{}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(condition, fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filters.Type != "" {
+		add("activity_type = %s", filters.Type)
+	}
+	if filters.Runs {
+		where = append(where, "activity_type IN ('Run', 'VirtualRun')")
+	}
+	if filters.Start != nil {
+		add("start_date >= %s", *filters.Start)
+	}
+	if filters.End != nil {
+		add("start_date < %s", *filters.End)
+	}
+	if filters.StartDate != nil {
+		add("start_date_local >= %s", localMidnight(*filters.StartDate))
+	}
+	if filters.EndDate != nil {
+		add("start_date_local < %s", localMidnight(*filters.EndDate))
+	}
+	if filters.Race != nil {
+		if *filters.Race {
+			where = append(where, "workout_type = 1")
+		} else {
+			where = append(where, "workout_type != 1")
+		}
+	}
+	if filters.WorkoutType != nil {
+		add("workout_type = %s", *filters.WorkoutType)
+	}
+	if filters.MinDistance > 0 {
+		add("distance >= %s", filters.MinDistance)
+	}
+	if filters.MaxDistance > 0 {
+		add("distance <= %s", filters.MaxDistance)
+	}
+	if filters.Name != "" {
+		add("value->>'name' ILIKE '%%' || %s || '%%' ESCAPE '\\'", escapeLike(filters.Name))
+	}
+
+	if len(where) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+func (s *PostgresStore) LoadPage(filters ActivityFilter, after *ActivityCursor, limit int) ([]SummaryActivity, error) {
+	where, args := s.filterClause(filters)
+	if after != nil {
+		args = append(args, after.Start, after.Id)
+		where = andWhere(where, fmt.Sprintf("(start_date, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	args = append(args, limit)
+	return s.activityQuery(
+		fmt.Sprintf("SELECT value FROM strava_activities%s ORDER BY start_date DESC, id DESC LIMIT $%d", where, len(args)),
+		args...,
+	)
+}
+
+func (s *PostgresStore) Load(filters ActivityFilter) ([]SummaryActivity, error) {
+	where, args := s.filterClause(filters)
+	return s.activityQuery("SELECT value FROM strava_activities"+where+" ORDER BY start_date DESC, id DESC", args...)
+}
+
+func (s *PostgresStore) Count(filters ActivityFilter) (int, error) {
+	where, args := s.filterClause(filters)
+	var count int
+	err := s.db.QueryRow("SELECT count(*) FROM strava_activities"+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) TotalsByYear(filters ActivityFilter) ([]YearTotals, error) {
+	where, args := s.filterClause(filters)
+	rows, err := s.db.Query(`
+		SELECT
+			extract(year FROM start_date_local)::int,
+			count(*),
+			coalesce(sum(distance), 0),
+			coalesce(sum(moving_time), 0),
+			coalesce(sum(elevation_gain), 0)
+		FROM strava_activities`+where+`
+		GROUP BY 1
+		ORDER BY 1`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []YearTotals{}
+	for rows.Next() {
+		var t YearTotals
+		if err := rows.Scan(&t.Year, &t.Activities, &t.Distance, &t.MovingTime, &t.ElevationGain); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+func (s *PostgresStore) TotalsByDay(filters ActivityFilter) ([]DayTotals, error) {
+	where, args := s.filterClause(filters)
+	rows, err := s.db.Query(`
+		SELECT
+			to_char(start_date_local, 'YYYY-MM-DD'),
+			count(*),
+			coalesce(sum(distance), 0),
+			coalesce(sum(moving_time), 0),
+			coalesce(sum(elevation_gain), 0)
+		FROM strava_activities`+where+`
+		GROUP BY 1
+		ORDER BY 1`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanDayTotals(rows)
+}
+
+func (s *PostgresStore) SaveRecordEfforts(activityId int64, efforts []RecordEffort) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM strava_record_efforts WHERE activity_id=$1", activityId); err != nil {
+		return err
+	}
+	for _, effort := range efforts {
+		_, err := tx.Exec(
+			`INSERT INTO strava_record_efforts (activity_id, record, source, distance, seconds, start_date)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			activityId, effort.Record, effort.Source, effort.Distance, effort.Time, effort.StartDate,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) LoadRecordEfforts() ([]RecordEffort, error) {
+	rows, err := s.db.Query(`
+		SELECT activity_id, record, source, distance, seconds, start_date
+		FROM strava_record_efforts
+		ORDER BY start_date, activity_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	efforts := []RecordEffort{}
+	for rows.Next() {
+		var e RecordEffort
+		if err := rows.Scan(&e.ActivityId, &e.Record, &e.Source, &e.Distance, &e.Time, &e.StartDate); err != nil {
+			return nil, err
+		}
+		e.StartDate = e.StartDate.UTC()
+		efforts = append(efforts, e)
+	}
+	return efforts, rows.Err()
+}
+
+func (s *PostgresStore) SaveStreams(activityId int64, streams *ActivityStreams) error {
+	_, err := s.db.Exec(
+		`INSERT INTO strava_streams (activity_id, value, fetched_at) VALUES ($1, $2, $3)
+		ON CONFLICT (activity_id) DO UPDATE SET value=excluded.value, fetched_at=excluded.fetched_at`,
+		activityId, streams.Encode(), time.Now(),
+	)
+	return err
+}
+
+func (s *PostgresStore) LoadStreams(activityId int64) (*ActivityStreams, error) {
+	var value []byte
+	err := s.db.QueryRow("SELECT value FROM strava_streams WHERE activity_id=$1", activityId).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return DecodeStreams(value)
+}
+
+func (s *PostgresStore) LoadStreamsMatching(filters ActivityFilter) (map[int64]*ActivityStreams, error) {
+	where, args := s.filterClause(filters)
+	rows, err := s.db.Query(`
+		SELECT activity_id, value FROM strava_streams
+		WHERE activity_id IN (SELECT id FROM strava_activities`+where+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[int64]*ActivityStreams{}
+	for rows.Next() {
+		var activityId int64
+		var value []byte
+		if err := rows.Scan(&activityId, &value); err != nil {
+			return nil, err
+		}
+		streams, err := DecodeStreams(value)
+		if err != nil {
+			return nil, err
+		}
+		result[activityId] = streams
+	}
+	return result, rows.Err()
+}
+
+func (s *PostgresStore) DeleteStreams(activityId int64) error {
+	_, err := s.db.Exec("DELETE FROM strava_streams WHERE activity_id=$1", activityId)
+	return err
+}
+
+func (s *PostgresStore) GetZoneSettings() (*ZoneSettings, error) {
+	var bytes []byte
+	err := s.db.QueryRow("SELECT value FROM strava_zone_settings WHERE id=1").Scan(&bytes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var settings ZoneSettings
+	if err := json.Unmarshal(bytes, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (s *PostgresStore) SaveZoneSettings(settings ZoneSettings) error {
+	bytes, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO strava_zone_settings (id, value) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET value = excluded.value`,
+		bytes,
+	)
+	return err
+}
+
+func (s *PostgresStore) GetSyncCheckpoint(mode SyncMode) (*SyncCheckpoint, error) {
+	var checkpoint SyncCheckpoint
+	err := s.db.QueryRow(
+		"SELECT after_time, before_time, page, started_at FROM strava_sync_checkpoint WHERE mode=$1",
+		mode,
+	).Scan(&checkpoint.After, &checkpoint.Before, &checkpoint.Page, &checkpoint.StartedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+func (s *PostgresStore) StartSyncCheckpoint(mode SyncMode, after, before time.Time) (*SyncCheckpoint, error) {
+	query := `INSERT INTO strava_sync_checkpoint (mode, after_time, before_time, page, started_at)
+		VALUES ($1, $2, $3, 0, now())
+		ON CONFLICT (mode)
+		DO UPDATE SET after_time = EXCLUDED.after_time, before_time = EXCLUDED.before_time, page = 0, started_at = EXCLUDED.started_at
+		RETURNING started_at`
+	checkpoint := SyncCheckpoint{After: after, Before: before}
+	if err := s.db.QueryRow(query, mode, after, before).Scan(&checkpoint.StartedAt); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+func (s *PostgresStore) SaveSyncCheckpoint(mode SyncMode, page int) error {
+	_, err := s.db.Exec("UPDATE strava_sync_checkpoint SET page=$1 WHERE mode=$2", page, mode)
+	return err
+}
+
+func (s *PostgresStore) ClearSyncCheckpoint(mode SyncMode) error {
+	_, err := s.db.Exec("DELETE FROM strava_sync_checkpoint WHERE mode=$1", mode)
+	return err
+}
+
+// DeleteSyncedBefore deletes activities that have not been synced since t,
+// returning how many were deleted.
+func (s *PostgresStore) DeleteSyncedBefore(t time.Time) (int, error) {
+	// An empty listing is far more likely to be an API problem than every
+	// activity having been deleted
+	var seen int
+	if err := s.db.QueryRow("SELECT count(*) FROM strava_activities WHERE synced_at >= $1", t).Scan(&seen); err != nil {
+		return 0, err
+	}
+	if seen == 0 {
+		return 0, nil
+	}
+
+	rows, err := s.db.Query("SELECT id FROM strava_activities WHERE synced_at IS NULL OR synced_at < $1", t)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		log.Printf("strava: activity %d was deleted upstream", id)
+		if err := s.Delete(id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (s *PostgresStore) StartSyncRun(mode SyncMode) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(
+		"INSERT INTO strava_sync_runs (mode, started_at) VALUES ($1, now()) RETURNING id",
+		mode,
+	).Scan(&id)
+	return id, err
+}
+
+func (s *PostgresStore) FinishSyncRun(id int64, stats SyncStats, syncErr error) error {
+	var errorString sql.NullString
+	if syncErr != nil {
+		errorString = sql.NullString{String: syncErr.Error(), Valid: true}
+	}
+	_, err := s.db.Exec(
+		`UPDATE strava_sync_runs SET
+			finished_at = now(),
+			pages = $1,
+			activities_inserted = $2,
+			activities_updated = $3,
+			activities_deleted = $4,
+			laps_inserted = $5,
+			laps_updated = $6,
+			error = $7
+		WHERE id = $8`,
+		stats.Pages,
+		stats.ActivitiesInserted,
+		stats.ActivitiesUpdated,
+		stats.ActivitiesDeleted,
+		stats.LapsInserted,
+		stats.LapsUpdated,
+		errorString,
+		id,
+	)
+	return err
+}
+
+func (s *PostgresStore) syncRunQuery(query string, args ...interface{}) ([]SyncRun, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []SyncRun{}
+	for rows.Next() {
+		var run SyncRun
+		var errorString sql.NullString
+		err := rows.Scan(
+			&run.Id,
+			&run.Mode,
+			&run.StartedAt,
+			&run.FinishedAt,
+			&run.Pages,
+			&run.ActivitiesInserted,
+			&run.ActivitiesUpdated,
+			&run.ActivitiesDeleted,
+			&run.LapsInserted,
+			&run.LapsUpdated,
+			&errorString,
+		)
+		if err != nil {
+			return nil, err
+		}
+		run.Error = errorString.String
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+const syncRunColumns = `id, mode, started_at, finished_at,
+	coalesce(pages, 0), coalesce(activities_inserted, 0), coalesce(activities_updated, 0),
+	coalesce(activities_deleted, 0), coalesce(laps_inserted, 0), coalesce(laps_updated, 0),
+	error`
+
+// SyncRuns returns the most recent sync runs, newest first.
+func (s *PostgresStore) SyncRuns(limit int) ([]SyncRun, error) {
+	return s.syncRunQuery(
+		"SELECT "+syncRunColumns+" FROM strava_sync_runs ORDER BY started_at DESC LIMIT $1",
+		limit,
+	)
+}
+
+// LastSuccessfulSyncRun returns the most recent sync in mode, or in any mode
+// if it's empty, that finished without error, or nil if there has never been
+// one.
+func (s *PostgresStore) LastSuccessfulSyncRun(mode SyncMode) (*SyncRun, error) {
+	runs, err := s.syncRunQuery(
+		"SELECT "+syncRunColumns+" FROM strava_sync_runs WHERE finished_at IS NOT NULL AND error IS NULL AND ($1 = '' OR mode = $1) ORDER BY finished_at DESC LIMIT 1",
+		string(mode),
+	)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return &runs[0], nil
+}
@@ -0,0 +1,66 @@
+package strava
+
+import "math"
+
+// Point is a position on a flat projection of the earth.
+type Point struct {
+	X float64
+	Y float64
+}
+
+// maxMercatorLat is where web mercator is cut off, making the world square.
+const maxMercatorLat = 85.05112878
+
+// Mercator projects p with web mercator onto the unit square, with (0, 0)
+// in the north west corner and (1, 1) in the south east, as map tiles are
+// laid out.
+func Mercator(p LatLng) Point {
+	lat := math.Max(-maxMercatorLat, math.Min(maxMercatorLat, p.Lat())) * math.Pi / 180
+	return Point{
+		X: (p.Lng() + 180) / 360,
+		Y: (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2,
+	}
+}
+
+// Simplify drops points that are within tolerance of the line through their
+// neighbours (Ramer-Douglas-Peucker), keeping the first and last.
+func Simplify(points []Point, tolerance float64) []Point {
+	if len(points) < 3 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	simplifyBetween(points, 0, len(points)-1, tolerance, keep)
+
+	simplified := []Point{}
+	for i, p := range points {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+func simplifyBetween(points []Point, first, last int, tolerance float64, keep []bool) {
+	furthest, distance := 0, tolerance
+	for i := first + 1; i < last; i++ {
+		if d := segmentDistance(points[i], points[first], points[last]); d > distance {
+			furthest, distance = i, d
+		}
+	}
+	if furthest != 0 {
+		keep[furthest] = true
+		simplifyBetween(points, first, furthest, tolerance, keep)
+		simplifyBetween(points, furthest, last, tolerance, keep)
+	}
+}
+
+// segmentDistance is the distance from p to the segment from a to b.
+func segmentDistance(p, a, b Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/length))
+	}
+	return math.Hypot(p.X-(a.X+t*dx), p.Y-(a.Y+t*dy))
+}
@@ -0,0 +1,105 @@
+package strava
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RaceCategories are the distances races are grouped by, every record
+// distance but the mile.
+var RaceCategories = RecordDistances[1:]
+
+// RaceCategoryOther is the category of races that aren't near a standard
+// distance.
+const RaceCategoryOther = "other"
+
+// Races are measured by GPS, which over a whole race usually reads long, so
+// allow more slack than for records.
+const (
+	raceMinRatio = 0.97
+	raceMaxRatio = 1.05
+)
+
+// RaceCategory returns the key of the race category for a distance in
+// meters, or RaceCategoryOther.
+func RaceCategory(distance float64) string {
+	for _, category := range RaceCategories {
+		if distance >= category.Meters*raceMinRatio && distance <= category.Meters*raceMaxRatio {
+			return category.Key
+		}
+	}
+	return RaceCategoryOther
+}
+
+var (
+	raceYear        = regexp.MustCompile(`\b(19|20)\d{2}\b`)
+	raceNonAlphaNum = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// RaceSeries normalizes a race's name so that editions from different years
+// match, e.g. "Boston Marathon 2023" and "2024 boston marathon!".
+func RaceSeries(name string) string {
+	series := raceYear.ReplaceAllString(strings.ToLower(name), " ")
+	return strings.TrimSpace(raceNonAlphaNum.ReplaceAllString(series, " "))
+}
+
+// FinishTime is the elapsed time, which is what a race is timed on, falling
+// back to moving time for activities stored before it was.
+func (a *SummaryActivity) FinishTime() float64 {
+	if a.ElapsedTime > 0 {
+		return a.ElapsedTime
+	}
+	return a.MovingTime
+}
+
+func (a *SummaryActivity) FinishTimeString() string {
+	return formatDuration(a.FinishTime())
+}
+
+// RacePacePerMile is the pace over the finish time.
+func (a *SummaryActivity) RacePacePerMile() string {
+	return formatPace(a.FinishTime(), a.Miles())
+}
+
+// RaceResult is a race along with the previous edition of the same race, if
+// there is one.
+type RaceResult struct {
+	Activity SummaryActivity
+	Category string
+	Series   string
+	Previous *SummaryActivity
+}
+
+// Races returns a RaceResult for every race in activities, newest first.
+func Races(activities []SummaryActivity) []RaceResult {
+	races := []SummaryActivity{}
+	for _, activity := range activities {
+		if activity.IsRace() {
+			races = append(races, activity)
+		}
+	}
+	sort.Sort(SummaryActivityDateSort(races))
+
+	results := []RaceResult{}
+	last := map[string]SummaryActivity{}
+	for _, race := range races {
+		result := RaceResult{
+			Activity: race,
+			Category: RaceCategory(race.Distance),
+			Series:   RaceSeries(race.Name),
+		}
+		// A series is only the same race if it's also the same distance
+		key := result.Series + "/" + result.Category
+		if previous, ok := last[key]; ok && result.Series != "" {
+			result.Previous = &previous
+		}
+		last[key] = race
+		results = append(results, result)
+	}
+
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+	return results
+}
@@ -0,0 +1,205 @@
+package strava
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Strava allows 100 requests per 15 minutes and 1000 per day by default.
+// See https://developers.strava.com/docs/rate-limits/
+const (
+	defaultShortTermLimit = 100
+	shortTermWindow       = 15 * time.Minute
+)
+
+type RateLimitWindow struct {
+	Limit    int       `json:"limit"`
+	Usage    int       `json:"usage"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+func (w RateLimitWindow) Remaining() int {
+	if w.Usage >= w.Limit {
+		return 0
+	}
+	return w.Limit - w.Usage
+}
+
+func (w RateLimitWindow) exhausted(now time.Time) bool {
+	return w.Limit > 0 && w.Usage >= w.Limit && now.Before(w.ResetsAt)
+}
+
+// RateLimitStatus is the quota usage Strava last reported to us.
+type RateLimitStatus struct {
+	ShortTerm RateLimitWindow `json:"fifteen_minute"`
+	Daily     RateLimitWindow `json:"daily"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// quota throttles requests using a token bucket that is slowed down as the
+// usage reported by Strava approaches either limit.
+type quota struct {
+	mu      sync.Mutex
+	limiter *rate.Limiter
+	base    rate.Limit
+	status  RateLimitStatus
+	now     func() time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+func newQuota(now func() time.Time, sleep func(context.Context, time.Duration) error, limiter *rate.Limiter) *quota {
+	if limiter == nil {
+		limiter = rate.NewLimiter(rate.Limit(float64(defaultShortTermLimit)/shortTermWindow.Seconds()), 10)
+	}
+	return &quota{
+		limiter: limiter,
+		base:    limiter.Limit(),
+		now:     now,
+		sleep:   sleep,
+	}
+}
+
+func (q *quota) Status() RateLimitStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.status
+}
+
+// below reports whether less than fraction of both windows has been used,
+// or if Strava hasn't reported any usage yet.
+func (q *quota) below(fraction float64) bool {
+	status := q.Status()
+	now := q.now()
+	for _, w := range []RateLimitWindow{status.ShortTerm, status.Daily} {
+		if w.Limit > 0 && now.Before(w.ResetsAt) && float64(w.Usage) >= float64(w.Limit)*fraction {
+			return false
+		}
+	}
+	return true
+}
+
+// wait blocks until a request may be made, pausing until the window resets
+// if either quota has been used up.
+func (q *quota) wait(ctx context.Context) error {
+	now := q.now()
+	status := q.Status()
+	var until time.Time
+	if status.Daily.exhausted(now) {
+		until = status.Daily.ResetsAt
+	} else if status.ShortTerm.exhausted(now) {
+		until = status.ShortTerm.ResetsAt
+	}
+	if !until.IsZero() {
+		log.Printf("strava rate limit exhausted, pausing until %s", until.Format(time.RFC3339))
+		if err := q.sleep(ctx, until.Sub(now)); err != nil {
+			return err
+		}
+	}
+	return q.limiter.Wait(ctx)
+}
+
+// update records the X-RateLimit-* headers from a response and adjusts the
+// request rate so the remaining quota is spread over the rest of the window.
+func (q *quota) update(header http.Header) {
+	limits := parseRateLimitHeader(header.Get("X-RateLimit-Limit"))
+	usage := parseRateLimitHeader(header.Get("X-RateLimit-Usage"))
+	if limits == nil || usage == nil {
+		return
+	}
+
+	now := q.now().UTC()
+	status := RateLimitStatus{
+		ShortTerm: RateLimitWindow{
+			Limit:    limits[0],
+			Usage:    usage[0],
+			ResetsAt: now.Truncate(shortTermWindow).Add(shortTermWindow),
+		},
+		Daily: RateLimitWindow{
+			Limit:    limits[1],
+			Usage:    usage[1],
+			ResetsAt: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+		},
+		UpdatedAt: now,
+	}
+
+	limit := q.base
+	for _, w := range []RateLimitWindow{status.ShortTerm, status.Daily} {
+		// Only slow down once 80% of a window is used.  A used up window is
+		// left to wait, which pauses until it resets: a zero limit would eat
+		// into the limiter's burst with every request.
+		if w.Remaining()*5 > w.Limit || w.Remaining() == 0 {
+			continue
+		}
+		spread := rate.Limit(float64(w.Remaining()) / w.ResetsAt.Sub(now).Seconds())
+		if spread < limit {
+			limit = spread
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.status = status
+	if limit != q.limiter.Limit() {
+		log.Printf("strava rate limit usage %d/%d (15m), %d/%d (daily): throttling to %.3f req/s",
+			status.ShortTerm.Usage, status.ShortTerm.Limit, status.Daily.Usage, status.Daily.Limit, float64(limit))
+		q.limiter.SetLimit(limit)
+	}
+}
+
+// retryAfter returns how long to wait before retrying a request that got a
+// 429: until the daily window resets if it's used up, and otherwise until the
+// 15-minute window does.
+func (q *quota) retryAfter() time.Duration {
+	now := q.now()
+	status := q.Status()
+	if status.Daily.exhausted(now) {
+		return status.Daily.ResetsAt.Sub(now)
+	}
+	return now.UTC().Truncate(shortTermWindow).Add(shortTermWindow).Sub(now)
+}
+
+// parseRateLimitHeader parses a "15-minute,daily" header value.
+func parseRateLimitHeader(value string) []int {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return nil
+	}
+	result := make([]int, 2)
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil
+		}
+		result[i] = n
+	}
+	return result
+}
+
+// retryBackoff returns an exponential backoff with full jitter for the given
+// (1-based) attempt.
+func retryBackoff(attempt int) time.Duration {
+	d := time.Second << attempt
+	if d > time.Minute {
+		d = time.Minute
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,216 @@
+package strava
+
+import (
+	"sort"
+	"time"
+)
+
+// RecordDistance is a standard distance that personal records are kept for.
+type RecordDistance struct {
+	Key    string  `json:"key"`
+	Name   string  `json:"name"`
+	Meters float64 `json:"meters"`
+}
+
+var RecordDistances = []RecordDistance{
+	{"mile", "Mile", MetersPerMile},
+	{"5k", "5K", 5000},
+	{"10k", "10K", 10000},
+	{"half_marathon", "Half Marathon", 21097.5},
+	{"marathon", "Marathon", 42195},
+}
+
+// LongestRun is the record for the greatest distance in one run, rather
+// than the fastest time over a fixed distance.
+const LongestRun = "longest_run"
+
+// Where a RecordEffort was found
+const (
+	EffortSourceActivity   = "activity"
+	EffortSourceLaps       = "laps"
+	EffortSourceBestEffort = "best_effort"
+)
+
+// An activity or run of laps counts towards a record distance if it's within
+// these bounds of it, allowing for GPS error and courses that run a little
+// long.  Its whole time is used, so a long course only ever hurts.
+const (
+	recordMinRatio = 0.99
+	recordMaxRatio = 1.03
+)
+
+// RecordEffort is an activity's best time over a record distance from one
+// source.  Time is elapsed seconds, as races and Strava's best efforts are
+// timed, and for LongestRun, Distance is what counts.
+type RecordEffort struct {
+	Record     string    `json:"record"`
+	ActivityId int64     `json:"activity_id"`
+	Source     string    `json:"source"`
+	Distance   float64   `json:"distance"`
+	Time       float64   `json:"time"`
+	StartDate  time.Time `json:"start_date"`
+}
+
+func (e *RecordEffort) TimeString() string {
+	return formatDuration(e.Time)
+}
+
+func (e *RecordEffort) PacePerMile() string {
+	return formatPace(e.Time, metersToMiles(e.Distance))
+}
+
+// beats reports whether e is a better effort than other for their record.
+func (e RecordEffort) beats(other RecordEffort) bool {
+	if e.Record == LongestRun {
+		return e.Distance > other.Distance
+	}
+	return e.Time < other.Time
+}
+
+func isRun(activity SummaryActivity) bool {
+	return activity.Type == "Run" || activity.Type == "VirtualRun"
+}
+
+// lapTime is a lap's elapsed time, falling back to moving time like
+// FinishTime does.
+func lapTime(lap ActivityLap) float64 {
+	if lap.ElapsedTime > 0 {
+		return float64(lap.ElapsedTime)
+	}
+	return float64(lap.MovingTime)
+}
+
+func withinRecordDistance(distance, target float64) bool {
+	return distance >= target*recordMinRatio && distance <= target*recordMaxRatio
+}
+
+// ComputeEfforts finds an activity's best effort at each record distance
+// from each source: the whole activity, runs of consecutive laps, and
+// Strava's best efforts.
+func ComputeEfforts(activity SummaryActivity, laps []ActivityLap, bestEfforts []BestEffort) []RecordEffort {
+	if !isRun(activity) {
+		return []RecordEffort{}
+	}
+	start := activity.StartUTC()
+	best := map[[2]string]RecordEffort{}
+	consider := func(effort RecordEffort) {
+		key := [2]string{effort.Record, effort.Source}
+		if current, ok := best[key]; !ok || effort.beats(current) {
+			best[key] = effort
+		}
+	}
+
+	finish := activity.FinishTime()
+	if activity.Distance > 0 && finish > 0 {
+		consider(RecordEffort{LongestRun, activity.Id, EffortSourceActivity, activity.Distance, finish, start})
+	}
+
+	for _, record := range RecordDistances {
+		if withinRecordDistance(activity.Distance, record.Meters) && finish > 0 {
+			consider(RecordEffort{record.Key, activity.Id, EffortSourceActivity, activity.Distance, finish, start})
+		}
+
+		for i := range laps {
+			distance, seconds := 0.0, 0.0
+			for j := i; j < len(laps) && distance < record.Meters*recordMinRatio; j++ {
+				distance += laps[j].Distance
+				seconds += lapTime(laps[j])
+			}
+			if withinRecordDistance(distance, record.Meters) && seconds > 0 {
+				consider(RecordEffort{record.Key, activity.Id, EffortSourceLaps, distance, seconds, start})
+			}
+		}
+
+		for _, effort := range bestEfforts {
+			// Strava's best effort distances are exact, but match loosely
+			// rather than relying on their names
+			if effort.Distance >= record.Meters*0.999 && effort.Distance <= record.Meters*1.001 && effort.ElapsedTime > 0 {
+				consider(RecordEffort{record.Key, activity.Id, EffortSourceBestEffort, effort.Distance, float64(effort.ElapsedTime), start})
+			}
+		}
+	}
+
+	efforts := []RecordEffort{}
+	for _, effort := range best {
+		efforts = append(efforts, effort)
+	}
+	sort.Slice(efforts, func(i, j int) bool {
+		if efforts[i].Record != efforts[j].Record {
+			return efforts[i].Record < efforts[j].Record
+		}
+		return efforts[i].Source < efforts[j].Source
+	})
+	return efforts
+}
+
+// RecordProgression returns, for each record, the efforts that set a new
+// record at the time, oldest first, with at most one per activity.  The
+// last one is the current record.  efforts must be ordered by start date and
+// then activity, as LoadRecordEfforts returns them.
+func RecordProgression(efforts []RecordEffort) map[string][]RecordEffort {
+	progression := map[string][]RecordEffort{}
+	for _, effort := range efforts {
+		history := progression[effort.Record]
+		switch {
+		case len(history) == 0:
+			progression[effort.Record] = []RecordEffort{effort}
+		case !effort.beats(history[len(history)-1]):
+		case history[len(history)-1].ActivityId == effort.ActivityId:
+			// A better source for the same activity
+			history[len(history)-1] = effort
+		default:
+			progression[effort.Record] = append(history, effort)
+		}
+	}
+	return progression
+}
+
+// updateEfforts recomputes and saves the record efforts of one activity.
+func updateEfforts(store Store, activity SummaryActivity, laps []ActivityLap, bestEfforts []BestEffort) error {
+	return store.SaveRecordEfforts(activity.Id, ComputeEfforts(activity, laps, bestEfforts))
+}
+
+// RecomputeRecords rebuilds every activity's record efforts from what's
+// stored.  Strava best efforts aren't stored apart from the efforts they
+// produced, so those are carried over rather than recomputed.
+func RecomputeRecords(store Store) error {
+	existing, err := store.LoadRecordEfforts()
+	if err != nil {
+		return err
+	}
+	bestEfforts := map[int64][]BestEffort{}
+	hasEfforts := map[int64]bool{}
+	for _, effort := range existing {
+		hasEfforts[effort.ActivityId] = true
+		if effort.Source == EffortSourceBestEffort {
+			bestEfforts[effort.ActivityId] = append(bestEfforts[effort.ActivityId], BestEffort{
+				Distance:    effort.Distance,
+				ElapsedTime: int32(effort.Time),
+			})
+		}
+	}
+
+	activities, err := store.Load(ActivityFilter{})
+	if err != nil {
+		return err
+	}
+	for _, activity := range activities {
+		if !isRun(activity) {
+			// Only runs have efforts, but this one may not always have been
+			if hasEfforts[activity.Id] {
+				if err := store.SaveRecordEfforts(activity.Id, []RecordEffort{}); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		laps, err := store.LoadLaps(activity.Id)
+		if err != nil {
+			return err
+		}
+		if err := updateEfforts(store, activity, laps, bestEfforts[activity.Id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
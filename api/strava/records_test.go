@@ -0,0 +1,104 @@
+package strava_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+func effortsString(efforts []strava.RecordEffort) string {
+	parts := []string{}
+	for _, effort := range efforts {
+		parts = append(parts, fmt.Sprintf("%s/%s %d:%.0fm %.0fs", effort.Record, effort.Source, effort.ActivityId, effort.Distance, effort.Time))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestComputeEfforts(t *testing.T) {
+	activity := func(activityType string, distance, movingTime, elapsedTime float64) strava.SummaryActivity {
+		return strava.SummaryActivity{
+			Id:              1,
+			Type:            activityType,
+			StartDateString: "2024-03-01T12:00:00Z",
+			Distance:        distance,
+			MovingTime:      movingTime,
+			ElapsedTime:     elapsedTime,
+		}
+	}
+	laps := func(distance float64, elapsedTimes ...int32) []strava.ActivityLap {
+		laps := []strava.ActivityLap{}
+		for _, elapsed := range elapsedTimes {
+			laps = append(laps, strava.ActivityLap{Distance: distance, ElapsedTime: elapsed, MovingTime: elapsed - 5})
+		}
+		return laps
+	}
+
+	tests := []struct {
+		name        string
+		activity    strava.SummaryActivity
+		laps        []strava.ActivityLap
+		bestEfforts []strava.BestEffort
+		want        string
+	}{
+		{"not a run", activity("Ride", 5000, 1400, 1500), nil, nil, ""},
+		{
+			"5k on elapsed time", activity("Run", 5000, 1400, 1500), laps(1000, 300, 300, 300, 300, 300),
+			[]strava.BestEffort{{Distance: strava.MetersPerMile, ElapsedTime: 420, MovingTime: 400}},
+			"5k/activity 1:5000m 1500s, 5k/laps 1:5000m 1500s, longest_run/activity 1:5000m 1500s, mile/best_effort 1:1609m 420s",
+		},
+		{
+			"fastest run of laps", activity("VirtualRun", 3*strava.MetersPerMile, 1200, 1210), laps(strava.MetersPerMile, 410, 390, 400),
+			nil,
+			"longest_run/activity 1:4828m 1210s, mile/laps 1:1609m 390s",
+		},
+		{
+			"moving time without elapsed time", activity("Run", 10000, 3000, 0), []strava.ActivityLap{{Distance: 10000, MovingTime: 2990}},
+			nil,
+			"10k/activity 1:10000m 3000s, 10k/laps 1:10000m 2990s, longest_run/activity 1:10000m 3000s",
+		},
+		{
+			"too long for a record", activity("Run", 5200, 1500, 1500), nil,
+			[]strava.BestEffort{{Distance: 5000, ElapsedTime: 1440}, {Distance: 4900, ElapsedTime: 1400}},
+			"5k/best_effort 1:5000m 1440s, longest_run/activity 1:5200m 1500s",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := effortsString(strava.ComputeEfforts(test.activity, test.laps, test.bestEfforts))
+			if got != test.want {
+				t.Errorf("got %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRecordProgression(t *testing.T) {
+	effort := func(record string, activityId int64, source string, distance, seconds float64) strava.RecordEffort {
+		return strava.RecordEffort{Record: record, ActivityId: activityId, Source: source, Distance: distance, Time: seconds}
+	}
+	efforts := []strava.RecordEffort{
+		effort("5k", 1, strava.EffortSourceLaps, 5000, 1500),
+		effort("5k", 1, strava.EffortSourceActivity, 5000, 1490),
+		effort(strava.LongestRun, 1, strava.EffortSourceActivity, 5000, 1490),
+		effort("5k", 2, strava.EffortSourceActivity, 5000, 1510),
+		effort(strava.LongestRun, 2, strava.EffortSourceActivity, 6000, 1900),
+		effort("5k", 3, strava.EffortSourceBestEffort, 5000, 1490),
+		effort("5k", 3, strava.EffortSourceActivity, 5000, 1450),
+		effort(strava.LongestRun, 3, strava.EffortSourceActivity, 5500, 1700),
+	}
+	progression := strava.RecordProgression(efforts)
+	want := map[string]string{
+		"5k":              "5k/activity 1:5000m 1490s, 5k/activity 3:5000m 1450s",
+		strava.LongestRun: "longest_run/activity 1:5000m 1490s, longest_run/activity 2:6000m 1900s",
+	}
+	if len(progression) != len(want) {
+		t.Errorf("got records %v, want %v", progression, want)
+	}
+	for record, want := range want {
+		if got := effortsString(progression[record]); got != want {
+			t.Errorf("%s: got %s\nwant %s", record, got, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package strava
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// SyncScheduler periodically syncs activities from Strava into a Store.
+// Failed syncs are retried with exponential backoff, capped at the interval.
+// Every ReconcileInterval the incremental sync is replaced by a full
+// reconciliation, which picks up edits and deletions of older activities.
+type SyncScheduler struct {
+	Interval          time.Duration
+	ReconcileInterval time.Duration
+	MinBackoff        time.Duration
+	// OnActivitiesChanged, if set, is called after a sync or webhook event
+	// inserts, updates or deletes activities
+	OnActivitiesChanged func()
+
+	store         Store
+	connect       func() (*StravaClient, error)
+	triggers      chan SyncOptions
+	lastReconcile time.Time
+
+	mu     sync.Mutex
+	client *StravaClient
+}
+
+// NewSyncScheduler creates a scheduler that syncs into store every interval.
+// connect is called lazily, so that an unreachable Strava API never blocks
+// startup, and again after Strava rejects the session.
+func NewSyncScheduler(store Store, interval time.Duration, connect func() (*StravaClient, error)) *SyncScheduler {
+	return &SyncScheduler{
+		Interval:          interval,
+		ReconcileInterval: 24 * time.Hour,
+		MinBackoff:        10 * time.Second,
+		store:             store,
+		connect:           connect,
+		triggers:          make(chan SyncOptions, 1),
+	}
+}
+
+// Client returns the current Strava client, connecting if necessary.
+func (s *SyncScheduler) Client() (*StravaClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		client, err := s.connect()
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	return s.client, nil
+}
+
+// Disconnect drops the current client so the next sync reconnects.
+func (s *SyncScheduler) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.client = nil
+}
+
+// Trigger queues a sync to run as soon as the current one finishes.  It
+// returns false if another triggered sync is already waiting.
+func (s *SyncScheduler) Trigger(opts SyncOptions) bool {
+	select {
+	case s.triggers <- opts:
+		return true
+	default:
+		return false
+	}
+}
+
+// loadLastReconcile picks up when the last full sync finished, so that a
+// restart doesn't reconcile again before ReconcileInterval has passed.
+func (s *SyncScheduler) loadLastReconcile() {
+	run, err := s.store.LastSuccessfulSyncRun(SyncFull)
+	if err != nil {
+		log.Printf("strava: loading last full sync: %v", err)
+		return
+	}
+	if run != nil && run.FinishedAt != nil {
+		s.lastReconcile = *run.FinishedAt
+	}
+}
+
+func (s *SyncScheduler) scheduledOptions() SyncOptions {
+	if time.Since(s.lastReconcile) >= s.ReconcileInterval {
+		return SyncOptions{Mode: SyncFull}
+	}
+	return SyncOptions{Mode: SyncIncremental}
+}
+
+func (s *SyncScheduler) syncOnce(ctx context.Context, opts SyncOptions) error {
+	client, err := s.Client()
+	if err != nil {
+		return err
+	}
+	stats, err := client.SyncWithOptions(ctx, s.store, opts)
+	if stats.ActivitiesInserted+stats.ActivitiesUpdated+stats.ActivitiesDeleted > 0 {
+		// Even a failed sync may have saved some pages
+		s.activitiesChanged()
+	}
+	if errors.Is(err, ErrUnauthorized) {
+		// Reconnect from the stored session, which may have been renewed
+		s.Disconnect()
+	}
+	if err != nil {
+		return err
+	}
+	if opts.Mode == SyncFull {
+		s.lastReconcile = time.Now()
+	}
+	return nil
+}
+
+func (s *SyncScheduler) activitiesChanged() {
+	if s.OnActivitiesChanged != nil {
+		s.OnActivitiesChanged()
+	}
+}
+
+// Run syncs immediately and then on every tick, or whenever a sync is
+// triggered, until ctx is cancelled.  A failed sync is retried with the same
+// options so that it resumes from its checkpoint.
+func (s *SyncScheduler) Run(ctx context.Context) {
+	failures := 0
+	s.loadLastReconcile()
+	opts := s.scheduledOptions()
+	for {
+		start := time.Now()
+		wait := s.Interval
+		if err := s.syncOnce(ctx, opts); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			wait = s.backoff(failures)
+			log.Printf("strava %s sync failed (attempt %d, retrying in %s): %v", opts.Mode, failures, wait, err)
+		} else {
+			failures = 0
+			log.Printf("strava %s sync complete (%s)", opts.Mode, time.Since(start))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case opts = <-s.triggers:
+			timer.Stop()
+			failures = 0
+		case <-timer.C:
+			if failures == 0 {
+				opts = s.scheduledOptions()
+			}
+		}
+	}
+}
+
+func (s *SyncScheduler) backoff(failures int) time.Duration {
+	d := s.MinBackoff
+	for i := 1; i < failures && d < s.Interval; i++ {
+		d *= 2
+	}
+	if d > s.Interval {
+		d = s.Interval
+	}
+	return d
+}
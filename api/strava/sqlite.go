@@ -0,0 +1,808 @@
+package strava
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/scottfrazer/website/migrate"
+)
+
+// Timestamps are stored as fixed width UTC text so that they sort and
+// compare correctly as strings.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+func parseSQLiteTime(s string) (time.Time, error) {
+	return time.Parse(sqliteTimeFormat, s)
+}
+
+// SQLiteStore is a Store backed by a local SQLite file, so the site can be
+// run without Postgres.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", path))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate.Run(db, migrate.SQLite, MigrationScope, sqliteMigrations); err != nil {
+		return nil, err
+	}
+
+	return &SQLiteStore{db}, nil
+}
+
+func (s *SQLiteStore) GetMostRecentActivityDate() (time.Time, error) {
+	var t sql.NullString
+	if err := s.db.QueryRow("SELECT max(start_date) FROM strava_activities").Scan(&t); err != nil {
+		return time.Time{}, err
+	}
+	if !t.Valid {
+		return epoch, nil
+	}
+	return parseSQLiteTime(t.String)
+}
+
+func (s *SQLiteStore) GetSession() (*StravaSession, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM strava_session WHERE id=1").Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var session StravaSession
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (s *SQLiteStore) SaveSession(session *StravaSession) error {
+	bytes, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO strava_session (id, value)
+		VALUES (1, ?)
+		ON CONFLICT (id)
+		DO UPDATE SET value = excluded.value`
+	_, err = s.db.Exec(query, string(bytes))
+	return err
+}
+
+func (s *SQLiteStore) DeleteSession() error {
+	_, err := s.db.Exec("DELETE FROM strava_session WHERE id=1")
+	return err
+}
+
+// upsert writes value to the row with the given id, bumping synced_at always
+// and updated_at only if value changed.
+func (s *SQLiteStore) upsert(tx *sql.Tx, table string, id int64, columns map[string]interface{}, value string) (inserted bool, changed bool, err error) {
+	now := sqliteTime(time.Now())
+
+	var existing string
+	err = tx.QueryRow(fmt.Sprintf("SELECT value FROM %s WHERE id=?", table), id).Scan(&existing)
+	if err != nil && err != sql.ErrNoRows {
+		return false, false, err
+	}
+	inserted = err == sql.ErrNoRows
+	changed = inserted || existing != value
+
+	names := []string{"id", "value", "synced_at", "updated_at"}
+	args := []interface{}{id, value, now, now}
+	for name, arg := range columns {
+		names = append(names, name)
+		args = append(args, arg)
+	}
+
+	if !inserted && !changed {
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET synced_at=? WHERE id=?", table), now, id)
+		return false, false, err
+	}
+
+	query := fmt.Sprintf(
+		"INSERT OR REPLACE INTO %s (%s) VALUES (%s)",
+		table,
+		strings.Join(names, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "),
+	)
+	_, err = tx.Exec(query, args...)
+	return inserted, changed, err
+}
+
+func (s *SQLiteStore) Save(activities []SummaryActivity) (inserted []int64, updated []int64, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	inserted, updated = []int64{}, []int64{}
+	for _, activity := range activities {
+		start, err := activity.ParseStart()
+		if err != nil {
+			return nil, nil, err
+		}
+		serialized, err := json.Marshal(activity)
+		if err != nil {
+			return nil, nil, err
+		}
+		pending, err := s.detailsPending(tx, activity)
+		if err != nil {
+			return nil, nil, err
+		}
+		isInserted, isChanged, err := s.upsert(tx, "strava_activities", activity.Id, map[string]interface{}{
+			"start_date":       sqliteTime(start),
+			"start_date_local": activity.StartLocal().Format(localTimeFormat),
+			"activity_type":    activity.Type,
+			"distance":         activity.Distance,
+			"moving_time":      activity.MovingTime,
+			"workout_type":     activity.WorkoutType,
+			"elevation_gain":   activity.ElevationGain,
+			"details_pending":  pending,
+		}, string(serialized))
+		if err != nil {
+			return nil, nil, err
+		}
+		if isInserted {
+			inserted = append(inserted, activity.Id)
+		} else if isChanged {
+			updated = append(updated, activity.Id)
+		}
+	}
+	return inserted, updated, tx.Commit()
+}
+
+// detailsPending reports whether activity's details need fetching once it's
+// saved: if it's new, was already pending or has changed since it was stored.
+func (s *SQLiteStore) detailsPending(tx *sql.Tx, activity SummaryActivity) (bool, error) {
+	var value string
+	var pending bool
+	err := tx.QueryRow("SELECT value, details_pending FROM strava_activities WHERE id=?", activity.Id).Scan(&value, &pending)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil || pending {
+		return pending, err
+	}
+	var stored SummaryActivity
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return false, err
+	}
+	return detailsChanged(stored, activity), nil
+}
+
+func (s *SQLiteStore) SaveLaps(activityId int64, laps []ActivityLap) (inserted int, updated int, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	ids := []interface{}{}
+	for _, lap := range laps {
+		serialized, err := json.Marshal(lap)
+		if err != nil {
+			return 0, 0, err
+		}
+		isInserted, isChanged, err := s.upsert(tx, "strava_laps", lap.Id, map[string]interface{}{
+			"activity_id": activityId,
+		}, string(serialized))
+		if err != nil {
+			return 0, 0, err
+		}
+		if isInserted {
+			inserted++
+		} else if isChanged {
+			updated++
+		}
+		ids = append(ids, lap.Id)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	if _, err := tx.Exec(
+		fmt.Sprintf("DELETE FROM strava_laps WHERE activity_id=? AND id NOT IN (%s)", placeholders),
+		append([]interface{}{activityId}, ids...)...,
+	); err != nil {
+		return 0, 0, err
+	}
+	return inserted, updated, tx.Commit()
+}
+
+func (s *SQLiteStore) Delete(activityId int64) error {
+	if _, err := s.db.Exec("DELETE FROM strava_streams WHERE activity_id=?", activityId); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM strava_record_efforts WHERE activity_id=?", activityId); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM strava_laps WHERE activity_id=?", activityId); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM strava_activities WHERE id=?", activityId)
+	return err
+}
+
+func (s *SQLiteStore) PendingDetails() ([]int64, error) {
+	rows, err := s.db.Query("SELECT id FROM strava_activities WHERE details_pending ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLiteStore) DetailsSaved(activityId int64) error {
+	_, err := s.db.Exec("UPDATE strava_activities SET details_pending = 0 WHERE id=?", activityId)
+	return err
+}
+
+func (s *SQLiteStore) activityQuery(query string, args ...interface{}) ([]SummaryActivity, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := []SummaryActivity{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		var activity SummaryActivity
+		if err := json.Unmarshal([]byte(value), &activity); err != nil {
+			return nil, err
+		}
+		activities = append(activities, activity)
+	}
+	return activities, rows.Err()
+}
+
+// filterClause returns the WHERE clause, if any, and its arguments for
+// filters.
+func (s *SQLiteStore) filterClause(filters ActivityFilter) (string, []interface{}) {
+	where := []string{}
+	args := []interface{}{}
+
+	if filters.Type != "" {
+		where = append(where, "activity_type = ?")
+		args = append(args, filters.Type)
+	}
+	if filters.Runs {
+		where = append(where, "activity_type IN ('Run', 'VirtualRun')")
+	}
+	if filters.Start != nil {
+		where = append(where, "start_date >= ?")
+		args = append(args, sqliteTime(*filters.Start))
+	}
+	if filters.End != nil {
+		where = append(where, "start_date < ?")
+		args = append(args, sqliteTime(*filters.End))
+	}
+	if filters.StartDate != nil {
+		where = append(where, "start_date_local >= ?")
+		args = append(args, localMidnight(*filters.StartDate))
+	}
+	if filters.EndDate != nil {
+		where = append(where, "start_date_local < ?")
+		args = append(args, localMidnight(*filters.EndDate))
+	}
+	if filters.Race != nil {
+		if *filters.Race {
+			where = append(where, "workout_type = 1")
+		} else {
+			where = append(where, "workout_type != 1")
+		}
+	}
+	if filters.WorkoutType != nil {
+		where = append(where, "workout_type = ?")
+		args = append(args, *filters.WorkoutType)
+	}
+	if filters.MinDistance > 0 {
+		where = append(where, "distance >= ?")
+		args = append(args, filters.MinDistance)
+	}
+	if filters.MaxDistance > 0 {
+		where = append(where, "distance <= ?")
+		args = append(args, filters.MaxDistance)
+	}
+	if filters.Name != "" {
+		// SQLite's LIKE already ignores case for ASCII
+		where = append(where, "json_extract(value, '$.name') LIKE '%' || ? || '%' ESCAPE '\\'")
+		args = append(args, escapeLike(filters.Name))
+	}
+
+	if len(where) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+func (s *SQLiteStore) LoadPage(filters ActivityFilter, after *ActivityCursor, limit int) ([]SummaryActivity, error) {
+	where, args := s.filterClause(filters)
+	if after != nil {
+		where = andWhere(where, "(start_date, id) < (?, ?)")
+		args = append(args, sqliteTime(after.Start), after.Id)
+	}
+	return s.activityQuery(
+		"SELECT value FROM strava_activities"+where+" ORDER BY start_date DESC, id DESC LIMIT ?",
+		append(args, limit)...,
+	)
+}
+
+func (s *SQLiteStore) Load(filters ActivityFilter) ([]SummaryActivity, error) {
+	where, args := s.filterClause(filters)
+	return s.activityQuery("SELECT value FROM strava_activities"+where+" ORDER BY start_date DESC, id DESC", args...)
+}
+
+func (s *SQLiteStore) Count(filters ActivityFilter) (int, error) {
+	where, args := s.filterClause(filters)
+	var count int
+	err := s.db.QueryRow("SELECT count(*) FROM strava_activities"+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s *SQLiteStore) TotalsByYear(filters ActivityFilter) ([]YearTotals, error) {
+	where, args := s.filterClause(filters)
+	rows, err := s.db.Query(`
+		SELECT
+			cast(substr(start_date_local, 1, 4) AS integer),
+			count(*),
+			coalesce(sum(distance), 0),
+			coalesce(sum(moving_time), 0),
+			coalesce(sum(elevation_gain), 0)
+		FROM strava_activities`+where+`
+		GROUP BY 1
+		ORDER BY 1`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []YearTotals{}
+	for rows.Next() {
+		var t YearTotals
+		if err := rows.Scan(&t.Year, &t.Activities, &t.Distance, &t.MovingTime, &t.ElevationGain); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+func (s *SQLiteStore) TotalsByDay(filters ActivityFilter) ([]DayTotals, error) {
+	where, args := s.filterClause(filters)
+	rows, err := s.db.Query(`
+		SELECT
+			substr(start_date_local, 1, 10),
+			count(*),
+			coalesce(sum(distance), 0),
+			coalesce(sum(moving_time), 0),
+			coalesce(sum(elevation_gain), 0)
+		FROM strava_activities`+where+`
+		GROUP BY 1
+		ORDER BY 1`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanDayTotals(rows)
+}
+
+func (s *SQLiteStore) LoadActivity(activityId int64) (*SummaryActivity, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM strava_activities WHERE id=?", activityId).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var activity SummaryActivity
+	if err := json.Unmarshal([]byte(value), &activity); err != nil {
+		return nil, err
+	}
+	return &activity, nil
+}
+
+func (s *SQLiteStore) LoadLaps(activityId int64) ([]ActivityLap, error) {
+	rows, err := s.db.Query(
+		"SELECT value FROM strava_laps WHERE activity_id=? ORDER BY json_extract(value, '$.lap_index')",
+		activityId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	laps := []ActivityLap{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		var lap ActivityLap
+		if err := json.Unmarshal([]byte(value), &lap); err != nil {
+			return nil, err
+		}
+		laps = append(laps, lap)
+	}
+	return laps, rows.Err()
+}
+
+func (s *SQLiteStore) LoadLapsMatching(filters ActivityFilter) (map[int64][]ActivityLap, error) {
+	where, args := s.filterClause(filters)
+	rows, err := s.db.Query(`
+		SELECT activity_id, value FROM strava_laps
+		WHERE activity_id IN (SELECT id FROM strava_activities`+where+`)
+		ORDER BY activity_id, json_extract(value, '$.lap_index')`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	laps := map[int64][]ActivityLap{}
+	for rows.Next() {
+		var activityId int64
+		var value string
+		if err := rows.Scan(&activityId, &value); err != nil {
+			return nil, err
+		}
+		var lap ActivityLap
+		if err := json.Unmarshal([]byte(value), &lap); err != nil {
+			return nil, err
+		}
+		laps[activityId] = append(laps[activityId], lap)
+	}
+	return laps, rows.Err()
+}
+
+func (s *SQLiteStore) SaveRecordEfforts(activityId int64, efforts []RecordEffort) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM strava_record_efforts WHERE activity_id=?", activityId); err != nil {
+		return err
+	}
+	for _, effort := range efforts {
+		_, err := tx.Exec(
+			`INSERT INTO strava_record_efforts (activity_id, record, source, distance, seconds, start_date)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			activityId, effort.Record, effort.Source, effort.Distance, effort.Time, sqliteTime(effort.StartDate),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) LoadRecordEfforts() ([]RecordEffort, error) {
+	rows, err := s.db.Query(`
+		SELECT activity_id, record, source, distance, seconds, start_date
+		FROM strava_record_efforts
+		ORDER BY start_date, activity_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	efforts := []RecordEffort{}
+	for rows.Next() {
+		var e RecordEffort
+		var startDate string
+		if err := rows.Scan(&e.ActivityId, &e.Record, &e.Source, &e.Distance, &e.Time, &startDate); err != nil {
+			return nil, err
+		}
+		if e.StartDate, err = parseSQLiteTime(startDate); err != nil {
+			return nil, err
+		}
+		efforts = append(efforts, e)
+	}
+	return efforts, rows.Err()
+}
+
+func (s *SQLiteStore) SaveStreams(activityId int64, streams *ActivityStreams) error {
+	_, err := s.db.Exec(
+		`INSERT INTO strava_streams (activity_id, value, fetched_at) VALUES (?, ?, ?)
+		ON CONFLICT (activity_id) DO UPDATE SET value=excluded.value, fetched_at=excluded.fetched_at`,
+		activityId, streams.Encode(), sqliteTime(time.Now()),
+	)
+	return err
+}
+
+func (s *SQLiteStore) LoadStreams(activityId int64) (*ActivityStreams, error) {
+	var value []byte
+	err := s.db.QueryRow("SELECT value FROM strava_streams WHERE activity_id=?", activityId).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return DecodeStreams(value)
+}
+
+func (s *SQLiteStore) LoadStreamsMatching(filters ActivityFilter) (map[int64]*ActivityStreams, error) {
+	where, args := s.filterClause(filters)
+	rows, err := s.db.Query(`
+		SELECT activity_id, value FROM strava_streams
+		WHERE activity_id IN (SELECT id FROM strava_activities`+where+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[int64]*ActivityStreams{}
+	for rows.Next() {
+		var activityId int64
+		var value []byte
+		if err := rows.Scan(&activityId, &value); err != nil {
+			return nil, err
+		}
+		streams, err := DecodeStreams(value)
+		if err != nil {
+			return nil, err
+		}
+		result[activityId] = streams
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteStreams(activityId int64) error {
+	_, err := s.db.Exec("DELETE FROM strava_streams WHERE activity_id=?", activityId)
+	return err
+}
+
+func (s *SQLiteStore) GetZoneSettings() (*ZoneSettings, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM strava_zone_settings WHERE id=1").Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var settings ZoneSettings
+	if err := json.Unmarshal([]byte(value), &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (s *SQLiteStore) SaveZoneSettings(settings ZoneSettings) error {
+	bytes, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO strava_zone_settings (id, value) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET value = excluded.value`,
+		string(bytes),
+	)
+	return err
+}
+
+func (s *SQLiteStore) GetSyncCheckpoint(mode SyncMode) (*SyncCheckpoint, error) {
+	var checkpoint SyncCheckpoint
+	var after, before, startedAt string
+	err := s.db.QueryRow(
+		"SELECT after_time, before_time, page, started_at FROM strava_sync_checkpoint WHERE mode=?",
+		mode,
+	).Scan(&after, &before, &checkpoint.Page, &startedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, t := range []struct {
+		dst *time.Time
+		src string
+	}{{&checkpoint.After, after}, {&checkpoint.Before, before}, {&checkpoint.StartedAt, startedAt}} {
+		if *t.dst, err = parseSQLiteTime(t.src); err != nil {
+			return nil, err
+		}
+	}
+	return &checkpoint, nil
+}
+
+func (s *SQLiteStore) StartSyncCheckpoint(mode SyncMode, after, before time.Time) (*SyncCheckpoint, error) {
+	checkpoint := SyncCheckpoint{After: after, Before: before, StartedAt: time.Now()}
+	_, err := s.db.Exec(
+		`INSERT OR REPLACE INTO strava_sync_checkpoint (mode, after_time, before_time, page, started_at)
+		VALUES (?, ?, ?, 0, ?)`,
+		mode,
+		sqliteTime(after),
+		sqliteTime(before),
+		sqliteTime(checkpoint.StartedAt),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+func (s *SQLiteStore) SaveSyncCheckpoint(mode SyncMode, page int) error {
+	_, err := s.db.Exec("UPDATE strava_sync_checkpoint SET page=? WHERE mode=?", page, mode)
+	return err
+}
+
+func (s *SQLiteStore) ClearSyncCheckpoint(mode SyncMode) error {
+	_, err := s.db.Exec("DELETE FROM strava_sync_checkpoint WHERE mode=?", mode)
+	return err
+}
+
+func (s *SQLiteStore) DeleteSyncedBefore(t time.Time) (int, error) {
+	// An empty listing is far more likely to be an API problem than every
+	// activity having been deleted
+	var seen int
+	if err := s.db.QueryRow("SELECT count(*) FROM strava_activities WHERE synced_at >= ?", sqliteTime(t)).Scan(&seen); err != nil {
+		return 0, err
+	}
+	if seen == 0 {
+		return 0, nil
+	}
+
+	rows, err := s.db.Query("SELECT id FROM strava_activities WHERE synced_at IS NULL OR synced_at < ?", sqliteTime(t))
+	if err != nil {
+		return 0, err
+	}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		log.Printf("strava: activity %d was deleted upstream", id)
+		if err := s.Delete(id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (s *SQLiteStore) StartSyncRun(mode SyncMode) (int64, error) {
+	result, err := s.db.Exec(
+		"INSERT INTO strava_sync_runs (mode, started_at) VALUES (?, ?)",
+		mode,
+		sqliteTime(time.Now()),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *SQLiteStore) FinishSyncRun(id int64, stats SyncStats, syncErr error) error {
+	var errorString sql.NullString
+	if syncErr != nil {
+		errorString = sql.NullString{String: syncErr.Error(), Valid: true}
+	}
+	_, err := s.db.Exec(
+		`UPDATE strava_sync_runs SET
+			finished_at = ?,
+			pages = ?,
+			activities_inserted = ?,
+			activities_updated = ?,
+			activities_deleted = ?,
+			laps_inserted = ?,
+			laps_updated = ?,
+			error = ?
+		WHERE id = ?`,
+		sqliteTime(time.Now()),
+		stats.Pages,
+		stats.ActivitiesInserted,
+		stats.ActivitiesUpdated,
+		stats.ActivitiesDeleted,
+		stats.LapsInserted,
+		stats.LapsUpdated,
+		errorString,
+		id,
+	)
+	return err
+}
+
+func (s *SQLiteStore) syncRunQuery(query string, args ...interface{}) ([]SyncRun, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []SyncRun{}
+	for rows.Next() {
+		var run SyncRun
+		var startedAt string
+		var finishedAt, errorString sql.NullString
+		err := rows.Scan(
+			&run.Id,
+			&run.Mode,
+			&startedAt,
+			&finishedAt,
+			&run.Pages,
+			&run.ActivitiesInserted,
+			&run.ActivitiesUpdated,
+			&run.ActivitiesDeleted,
+			&run.LapsInserted,
+			&run.LapsUpdated,
+			&errorString,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if run.StartedAt, err = parseSQLiteTime(startedAt); err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			t, err := parseSQLiteTime(finishedAt.String)
+			if err != nil {
+				return nil, err
+			}
+			run.FinishedAt = &t
+		}
+		run.Error = errorString.String
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func (s *SQLiteStore) SyncRuns(limit int) ([]SyncRun, error) {
+	return s.syncRunQuery(
+		"SELECT "+syncRunColumns+" FROM strava_sync_runs ORDER BY started_at DESC LIMIT ?",
+		limit,
+	)
+}
+
+func (s *SQLiteStore) LastSuccessfulSyncRun(mode SyncMode) (*SyncRun, error) {
+	runs, err := s.syncRunQuery(
+		"SELECT "+syncRunColumns+" FROM strava_sync_runs WHERE finished_at IS NOT NULL AND error IS NULL AND (? = '' OR mode = ?) ORDER BY finished_at DESC LIMIT 1",
+		string(mode), string(mode),
+	)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return &runs[0], nil
+}
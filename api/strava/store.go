@@ -0,0 +1,244 @@
+package strava
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Store persists everything synced from Strava: the session, activities and
+// their laps, and the bookkeeping for sync checkpoints and history.
+type Store interface {
+	SessionStore
+	DeleteSession() error
+
+	// GetMostRecentActivityDate returns the start of the newest activity,
+	// or the Unix epoch if there are none
+	GetMostRecentActivityDate() (time.Time, error)
+	// Save upserts activities, returning the ids of those that were inserted
+	// and of existing ones whose content changed
+	Save(activities []SummaryActivity) (inserted []int64, updated []int64, err error)
+	// SaveLaps replaces the laps of an activity, returning how many were
+	// inserted and changed
+	SaveLaps(activityId int64, laps []ActivityLap) (inserted int, updated int, err error)
+	// PendingDetails returns the ids of activities that Save inserted, or
+	// changed in a way that affects their details, and whose details haven't
+	// been saved since
+	PendingDetails() ([]int64, error)
+	// DetailsSaved records that an activity's laps and record efforts are
+	// up to date
+	DetailsSaved(activityId int64) error
+	Delete(activityId int64) error
+	// LoadPage returns up to limit of the activities matching filters,
+	// newest first, starting after the cursor if there is one
+	LoadPage(filters ActivityFilter, after *ActivityCursor, limit int) ([]SummaryActivity, error)
+	Load(filters ActivityFilter) ([]SummaryActivity, error)
+	Count(filters ActivityFilter) (int, error)
+	// TotalsByYear sums the activities matching filters by the local year
+	// they started in, oldest year first
+	TotalsByYear(filters ActivityFilter) ([]YearTotals, error)
+	// TotalsByDay sums the activities matching filters by the local day
+	// they started on, oldest first, skipping days without any
+	TotalsByDay(filters ActivityFilter) ([]DayTotals, error)
+	// LoadActivity returns a single activity, or nil if there is none with
+	// that id
+	LoadActivity(activityId int64) (*SummaryActivity, error)
+	// LoadLaps returns the laps of an activity ordered by lap index
+	LoadLaps(activityId int64) ([]ActivityLap, error)
+	// LoadLapsMatching returns the laps of the activities matching filters
+	// by activity id, each ordered by lap index
+	LoadLapsMatching(filters ActivityFilter) (map[int64][]ActivityLap, error)
+
+	// SaveRecordEfforts replaces the record efforts of an activity
+	SaveRecordEfforts(activityId int64, efforts []RecordEffort) error
+	// LoadRecordEfforts returns every record effort, oldest first
+	LoadRecordEfforts() ([]RecordEffort, error)
+
+	// SaveStreams replaces the streams of an activity
+	SaveStreams(activityId int64, streams *ActivityStreams) error
+	// LoadStreams returns the streams of an activity, or nil if they haven't
+	// been fetched
+	LoadStreams(activityId int64) (*ActivityStreams, error)
+	// LoadStreamsMatching returns the streams of the activities matching
+	// filters that have them, by activity id
+	LoadStreamsMatching(filters ActivityFilter) (map[int64]*ActivityStreams, error)
+	// DeleteStreams forgets the streams of an activity so that they're
+	// fetched again
+	DeleteStreams(activityId int64) error
+
+	// GetZoneSettings returns the heart rate and pace zone settings, or nil
+	// if they've never been saved
+	GetZoneSettings() (*ZoneSettings, error)
+	SaveZoneSettings(settings ZoneSettings) error
+
+	GetSyncCheckpoint(mode SyncMode) (*SyncCheckpoint, error)
+	StartSyncCheckpoint(mode SyncMode, after, before time.Time) (*SyncCheckpoint, error)
+	SaveSyncCheckpoint(mode SyncMode, page int) error
+	ClearSyncCheckpoint(mode SyncMode) error
+	// DeleteSyncedBefore deletes activities that have not been synced since
+	// t, returning how many were deleted
+	DeleteSyncedBefore(t time.Time) (int, error)
+
+	StartSyncRun(mode SyncMode) (int64, error)
+	FinishSyncRun(id int64, stats SyncStats, syncErr error) error
+	// SyncRuns returns the most recent sync runs, newest first
+	SyncRuns(limit int) ([]SyncRun, error)
+	// LastSuccessfulSyncRun returns the most recent sync in mode, or in any
+	// mode if it's empty, that finished without error, or nil if there has
+	// never been one
+	LastSuccessfulSyncRun(mode SyncMode) (*SyncRun, error)
+}
+
+// Totals sums a group of activities.  Distance is in meters and MovingTime
+// in seconds, as in SummaryActivity.
+type Totals struct {
+	Activities    int     `json:"activities"`
+	Distance      float64 `json:"distance"`
+	MovingTime    float64 `json:"moving_time"`
+	ElevationGain float64 `json:"elevation_gain"`
+}
+
+func (t *Totals) Add(other Totals) {
+	t.Activities += other.Activities
+	t.Distance += other.Distance
+	t.MovingTime += other.MovingTime
+	t.ElevationGain += other.ElevationGain
+}
+
+func (t *Totals) addActivity(activity SummaryActivity) {
+	t.Add(Totals{1, activity.Distance, activity.MovingTime, activity.ElevationGain})
+}
+
+func (t *Totals) Miles() float64 {
+	return metersToMiles(t.Distance)
+}
+
+func (t *Totals) MovingTimeString() string {
+	return formatDuration(t.MovingTime)
+}
+
+// PacePerMile is the average pace over all of the activities.
+func (t *Totals) PacePerMile() string {
+	return formatPace(t.MovingTime, t.Miles())
+}
+
+type YearTotals struct {
+	Year int `json:"year"`
+	Totals
+}
+
+// DayTotals sums the activities started on one local calendar day.  Date is
+// midnight UTC of that day.
+type DayTotals struct {
+	Date time.Time `json:"date"`
+	Totals
+}
+
+// ActivityFilter selects activities.  The zero value matches everything and
+// each field that is set narrows the selection further.
+type ActivityFilter struct {
+	// Type is the Strava activity type, e.g. "Run"
+	Type string
+	// Runs selects only runs, including virtual ones
+	Runs bool
+	// Start and End bound the activity start time, End is exclusive
+	Start *time.Time
+	End   *time.Time
+	// StartDate and EndDate bound the local date the activity started on,
+	// as the athlete saw it, EndDate is exclusive.  Their time of day and
+	// zone are ignored.
+	StartDate *time.Time
+	EndDate   *time.Time
+	// Race selects only races, or only non-races
+	Race        *bool
+	WorkoutType *int
+	// MinDistance and MaxDistance are in meters, zero means unbounded
+	MinDistance float64
+	MaxDistance float64
+	// Name matches activities whose name contains it, ignoring case
+	Name string
+}
+
+func (f ActivityFilter) Matches(activity SummaryActivity) bool {
+	date := activity.StartUTC()
+	if f.Type != "" && activity.Type != f.Type {
+		return false
+	}
+	if f.Runs && !isRun(activity) {
+		return false
+	}
+	if f.Start != nil && date.Before(*f.Start) {
+		return false
+	}
+	if f.End != nil && !date.Before(*f.End) {
+		return false
+	}
+	if f.StartDate != nil && activity.StartLocal().Format(localTimeFormat) < localMidnight(*f.StartDate) {
+		return false
+	}
+	if f.EndDate != nil && activity.StartLocal().Format(localTimeFormat) >= localMidnight(*f.EndDate) {
+		return false
+	}
+	if f.Race != nil && activity.IsRace() != *f.Race {
+		return false
+	}
+	if f.WorkoutType != nil && activity.WorkoutType != *f.WorkoutType {
+		return false
+	}
+	if f.MinDistance > 0 && activity.Distance < f.MinDistance {
+		return false
+	}
+	if f.MaxDistance > 0 && activity.Distance > f.MaxDistance {
+		return false
+	}
+	if f.Name != "" && !strings.Contains(strings.ToLower(activity.Name), strings.ToLower(f.Name)) {
+		return false
+	}
+	return true
+}
+
+// andWhere adds condition to a WHERE clause that may be empty.
+func andWhere(where, condition string) string {
+	if where == "" {
+		return " WHERE " + condition
+	}
+	return where + " AND " + condition
+}
+
+// escapeLike escapes the LIKE wildcards in s, for use with ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// scanDayTotals reads rows of (YYYY-MM-DD date, count, distance, moving
+// time, elevation gain) and closes them.
+func scanDayTotals(rows *sql.Rows) ([]DayTotals, error) {
+	defer rows.Close()
+	totals := []DayTotals{}
+	for rows.Next() {
+		var date string
+		var t DayTotals
+		if err := rows.Scan(&date, &t.Activities, &t.Distance, &t.MovingTime, &t.ElevationGain); err != nil {
+			return nil, err
+		}
+		parsed, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, err
+		}
+		t.Date = parsed
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// localTimeFormat is how the stores keep an activity's wall clock start time,
+// in its own time zone
+const localTimeFormat = "2006-01-02T15:04:05"
+
+// localMidnight is the start of date's day in localTimeFormat, for comparing
+// with wall clock start times.
+func localMidnight(date time.Time) string {
+	return date.Format("2006-01-02") + "T00:00:00"
+}
+
+var epoch = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
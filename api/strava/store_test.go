@@ -0,0 +1,51 @@
+package strava_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scottfrazer/website/strava"
+	"github.com/scottfrazer/website/strava/stravatest"
+)
+
+func TestTotalsByDayGroupsByLocalDate(t *testing.T) {
+	zone := func(name string) *time.Location {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return location
+	}
+	// In UTC order the local dates go Jan 2, Jan 1, Jan 2
+	activities := []strava.SummaryActivity{
+		stravatest.Run(1, "Tokyo", time.Date(2024, 1, 2, 1, 0, 0, 0, zone("Asia/Tokyo")), 3, 30*time.Minute),
+		stravatest.Run(2, "New York", time.Date(2024, 1, 1, 20, 0, 0, 0, zone("America/New_York")), 4, 40*time.Minute),
+		stravatest.Run(3, "Los Angeles", time.Date(2024, 1, 2, 6, 0, 0, 0, zone("America/Los_Angeles")), 5, 50*time.Minute),
+	}
+	want := []struct {
+		date       time.Time
+		activities int
+	}{
+		{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 1},
+		{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), 2},
+	}
+
+	forEachStore(t, func(t *testing.T, store strava.Store) {
+		if _, _, err := store.Save(activities); err != nil {
+			t.Fatal(err)
+		}
+		days, err := store.TotalsByDay(strava.ActivityFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(days) != len(want) {
+			t.Fatalf("got %d days, want %d: %+v", len(days), len(want), days)
+		}
+		for i, day := range days {
+			if !day.Date.Equal(want[i].date) || day.Activities != want[i].activities {
+				t.Errorf("day %d = %s with %d activities, want %s with %d",
+					i, day.Date, day.Activities, want[i].date, want[i].activities)
+			}
+		}
+	})
+}
@@ -3,11 +3,12 @@ package strava
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -18,24 +19,32 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"golang.org/x/time/rate"
-
-	_ "github.com/lib/pq"
 )
 
 type SummaryActivity struct {
-	Id          int64         `json:"id"`
-	Name        string        `json:"name"`
-	DateString  string        `json:"start_date_local"`
-	Distance    float64       `json:"distance"`
-	MovingTime  float64       `json:"moving_time"`
-	WorkoutType int           `json:"workout_type"`
-	Type        string        `json:"type"`
-	Map         ActivityMap   `json:"map"`
-	Laps        []ActivityLap `json:"laps"`
+	Id               int64         `json:"id"`
+	Name             string        `json:"name"`
+	DateString       string        `json:"start_date_local"`
+	StartDateString  string        `json:"start_date"`
+	Timezone         string        `json:"timezone"`
+	UtcOffset        float64       `json:"utc_offset"`
+	Distance         float64       `json:"distance"`
+	MovingTime       float64       `json:"moving_time"`
+	ElapsedTime      float64       `json:"elapsed_time"`
+	ElevationGain    float64       `json:"total_elevation_gain"`
+	AverageCadence   float64       `json:"average_cadence"`
+	HasHeartrate     bool          `json:"has_heartrate"`
+	AverageHeartrate float64       `json:"average_heartrate"`
+	MaxHeartrate     float64       `json:"max_heartrate"`
+	WorkoutType      int           `json:"workout_type"`
+	Type             string        `json:"type"`
+	Map              ActivityMap   `json:"map"`
+	Laps             []ActivityLap `json:"laps"`
 }
 
 type ActivityLap struct {
@@ -53,12 +62,34 @@ type ActivityLap struct {
 	AverageSpeed       float64   `json:"average_speed"`
 	MaxSpeed           float64   `json:"max_speed"`
 	AverageCadence     float64   `json:"average_cadence"`
+	AverageHeartrate   float64   `json:"average_heartrate"`
+	MaxHeartrate       float64   `json:"max_heartrate"`
 	DeviceWatts        bool      `json:"device_watts"`
 	AverageWats        float64   `json:"average_watts"`
 	LapIndex           int32     `json:"lap_index"`
 	Split              int32     `json:"split"`
 }
 
+// BestEffort is Strava's fastest time over a standard distance within an
+// activity, only included in the detailed activity.
+type BestEffort struct {
+	Id          int64     `json:"id"`
+	Name        string    `json:"name"`
+	ElapsedTime int32     `json:"elapsed_time"`
+	MovingTime  int32     `json:"moving_time"`
+	StartDate   time.Time `json:"start_date"`
+	Distance    float64   `json:"distance"`
+	StartIndex  int32     `json:"start_index"`
+	EndIndex    int32     `json:"end_index"`
+}
+
+// DetailedActivity is what Strava returns for a single activity, which
+// includes its laps and best efforts.
+type DetailedActivity struct {
+	SummaryActivity
+	BestEfforts []BestEffort `json:"best_efforts"`
+}
+
 type SummaryActivityDateSort []SummaryActivity
 
 func (tds SummaryActivityDateSort) Len() int {
@@ -70,7 +101,7 @@ func (tds SummaryActivityDateSort) Swap(i, j int) {
 }
 
 func (tds SummaryActivityDateSort) Less(i, j int) bool {
-	return tds[i].Date().Before(tds[j].Date())
+	return tds[i].StartUTC().Before(tds[j].StartUTC())
 }
 
 type ActivityMap struct {
@@ -79,26 +110,147 @@ type ActivityMap struct {
 	Polyline      string `json:"summary_polyline"`
 }
 
-func (a *SummaryActivity) Date() time.Time {
-	// TODO: ignoring error
-	t, _ := time.Parse("2006-01-02T15:04:05Z", a.DateString)
+// ParseStart returns the UTC start time of the activity.  Strava's
+// start_date_local is the local wall-clock time with a misleading "Z" suffix,
+// so it is only used (shifted by utc_offset) for activities stored before
+// start_date was captured.
+func (a *SummaryActivity) ParseStart() (time.Time, error) {
+	if a.StartDateString != "" {
+		t, err := time.Parse(time.RFC3339, a.StartDateString)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("activity %d: invalid start_date: %w", a.Id, err)
+		}
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, a.DateString)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("activity %d: invalid start_date_local: %w", a.Id, err)
+	}
+	return t.Add(-time.Duration(a.UtcOffset) * time.Second).UTC(), nil
+}
+
+// StartUTC returns the start time of the activity in UTC, or the zero time if
+// it cannot be parsed (see ParseStart).
+func (a *SummaryActivity) StartUTC() time.Time {
+	t, _ := a.ParseStart()
 	return t
 }
 
+// StartLocal returns the start time of the activity in the time zone it was
+// recorded in, so that its wall-clock time, date and year are as the athlete
+// saw them.
+func (a *SummaryActivity) StartLocal() time.Time {
+	return a.StartUTC().In(a.Location())
+}
+
+// Location returns the time zone of the activity, from the IANA name in
+// Strava's "(GMT-08:00) America/Los_Angeles" timezone field if possible and
+// otherwise from utc_offset.
+func (a *SummaryActivity) Location() *time.Location {
+	if i := strings.Index(a.Timezone, ") "); i >= 0 {
+		if loc, err := loadLocation(a.Timezone[i+2:]); err == nil {
+			return loc
+		}
+	}
+	return time.FixedZone("", int(a.UtcOffset))
+}
+
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// Date returns the local start time of the activity.
+func (a *SummaryActivity) Date() time.Time {
+	return a.StartLocal()
+}
+
+// detailsChanged reports whether an activity changed in a way that its laps
+// and record efforts may have too.  Only fields that activities have always
+// been stored with are compared, so that fields added to SummaryActivity
+// since don't make every stored activity's details look stale.
+func detailsChanged(old, new SummaryActivity) bool {
+	return old.Name != new.Name ||
+		old.Type != new.Type ||
+		old.DateString != new.DateString ||
+		old.Distance != new.Distance ||
+		old.MovingTime != new.MovingTime ||
+		old.WorkoutType != new.WorkoutType ||
+		old.Map.Polyline != new.Map.Polyline
+}
+
 func (a *SummaryActivity) IsRace() bool {
 	return a.WorkoutType == 1
 }
 
 func (a *SummaryActivity) Miles() float64 {
-	return (a.Distance / 1000) * 0.621371
+	return metersToMiles(a.Distance)
 }
 
 func (a *SummaryActivity) DistanceString() string {
-	return fmt.Sprintf("%s mi", strconv.FormatFloat(a.Miles(), 'f', 2, 64))
+	return formatMiles(a.Miles())
 }
 
 func (a *SummaryActivity) MovingTimeString() string {
-	d := time.Duration(a.MovingTime) * time.Second
+	return formatDuration(a.MovingTime)
+}
+
+func (a *SummaryActivity) PacePerMile() string {
+	return formatPace(a.MovingTime, a.Miles())
+}
+
+func (l *ActivityLap) Miles() float64 {
+	return metersToMiles(l.Distance)
+}
+
+func (l *ActivityLap) DistanceString() string {
+	return formatMiles(l.Miles())
+}
+
+func (l *ActivityLap) MovingTimeString() string {
+	return formatDuration(float64(l.MovingTime))
+}
+
+func (l *ActivityLap) PacePerMile() string {
+	return formatPace(float64(l.MovingTime), l.Miles())
+}
+
+// ElevationString is the lap's elevation gain in feet.
+func (l *ActivityLap) ElevationString() string {
+	return fmt.Sprintf("%d ft", int(math.Round(l.TotalElevationGain*3.28084)))
+}
+
+// CadenceString is the lap's cadence in steps per minute.  Strava reports
+// running cadence per foot, so it's doubled here.
+func (l *ActivityLap) CadenceString() string {
+	if l.AverageCadence == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d spm", int(math.Round(l.AverageCadence*2)))
+}
+
+// MetersPerMile converts Strava's distances in meters to miles
+const MetersPerMile = 1609.344
+
+func metersToMiles(meters float64) float64 {
+	return meters / MetersPerMile
+}
+
+func formatMiles(miles float64) string {
+	return fmt.Sprintf("%s mi", strconv.FormatFloat(miles, 'f', 2, 64))
+}
+
+func formatDuration(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
 	d = d.Round(time.Second)
 	h := d / time.Hour
 	d -= h * time.Hour
@@ -108,8 +260,11 @@ func (a *SummaryActivity) MovingTimeString() string {
 	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
 }
 
-func (a *SummaryActivity) PacePerMile() string {
-	d := time.Duration(a.MovingTime/a.Miles()) * time.Second
+func formatPace(seconds, miles float64) string {
+	if miles == 0 {
+		return "--:--"
+	}
+	d := time.Duration(seconds/miles) * time.Second
 	d = d.Round(time.Second)
 	m := d / time.Minute
 	d -= m * time.Minute
@@ -173,21 +328,78 @@ func (session StravaSession) Json() (string, error) {
 }
 
 func (session StravaSession) IsExpired() bool {
+	return session.isExpiredAt(time.Now())
+}
+
+func (session StravaSession) isExpiredAt(now time.Time) bool {
 	expiresAt := time.Unix(session.ExpiresAt, 0)
-	now := time.Now().UTC()
-	return now.After(expiresAt)
+	return now.UTC().After(expiresAt)
+}
+
+// SessionStore persists a StravaSession so that rotated refresh tokens
+// survive restarts.
+type SessionStore interface {
+	GetSession() (*StravaSession, error)
+	SaveSession(session *StravaSession) error
+}
+
+const DefaultBaseURL = "https://www.strava.com/api/v3"
+
+// ClientOptions overrides how a StravaClient talks to the API, mostly so it
+// can be pointed at a fake server.  Zero values select the defaults.
+type ClientOptions struct {
+	// BaseURL defaults to DefaultBaseURL
+	BaseURL string
+	// HTTPClient defaults to a plain http.Client
+	HTTPClient *http.Client
+	// Now defaults to time.Now and decides when the session has expired
+	// and when rate limit windows reset
+	Now func() time.Time
+	// Limiter defaults to Strava's standard 100 requests per 15 minutes
+	Limiter *rate.Limiter
+	// Sleep defaults to waiting on a timer, and is how the client waits for
+	// rate limit windows to reset and between retries
+	Sleep func(ctx context.Context, d time.Duration) error
 }
 
 type StravaClient struct {
-	session *StravaSession
-	limiter *rate.Limiter
+	mu       sync.Mutex
+	session  *StravaSession
+	sessions SessionStore
+	quota    *quota
+	baseURL  string
+	http     *http.Client
+	now      func() time.Time
 }
 
-func NewStravaClient() (*StravaClient, error) {
-	return nil, nil
+// NewStravaClient creates a client for session.  The access token is
+// refreshed lazily on the first request that needs it, and every refreshed
+// session is written back to sessions (which may be nil).
+func NewStravaClient(session StravaSession, sessions SessionStore, opts ClientOptions) (*StravaClient, error) {
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{}
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Sleep == nil {
+		opts.Sleep = sleep
+	}
+	return &StravaClient{
+		session:  &session,
+		sessions: sessions,
+		quota:    newQuota(opts.Now, opts.Sleep, opts.Limiter),
+		baseURL:  strings.TrimSuffix(opts.BaseURL, "/"),
+		http:     opts.HTTPClient,
+		now:      opts.Now,
+	}, nil
 }
 
-func refreshSession(session *StravaSession) error {
+func (c *StravaClient) refreshSession(ctx context.Context) error {
+	session := c.session
 	body, err := json.Marshal(map[string]string{
 		"client_id":     session.ClientId,
 		"client_secret": session.ClientSecret,
@@ -199,9 +411,10 @@ func refreshSession(session *StravaSession) error {
 		return err
 	}
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
-		"https://www.strava.com/api/v3/oauth/token",
+		c.baseURL+"/oauth/token",
 		bytes.NewReader(body),
 	)
 
@@ -211,8 +424,7 @@ func refreshSession(session *StravaSession) error {
 
 	req.Header.Add("content-type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return err
 	}
@@ -223,6 +435,13 @@ func refreshSession(session *StravaSession) error {
 		return err
 	}
 
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("refreshing session: %w: %d: %s", ErrUnauthorized, resp.StatusCode, jsonBytes)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("refreshing session: unexpected status: %d: %s", resp.StatusCode, jsonBytes)
+	}
+
 	var newSession StravaSession
 	if err := json.Unmarshal(jsonBytes, &newSession); err != nil {
 		return err
@@ -234,20 +453,32 @@ func refreshSession(session *StravaSession) error {
 	return nil
 }
 
-func NewStravaClientFromSession(session StravaSession) (*StravaClient, error) {
-	if session.IsExpired() {
-		if err := refreshSession(&session); err != nil {
-			return nil, err
+// NewStravaClientFromSession creates a client for session that talks to the
+// real Strava API.
+func NewStravaClientFromSession(session StravaSession, sessions SessionStore) (*StravaClient, error) {
+	return NewStravaClient(session, sessions, ClientOptions{})
+}
+
+// accessToken returns a valid access token, refreshing the session first if
+// it has expired or if force is set.
+func (c *StravaClient) accessToken(ctx context.Context, force bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if force || c.session.AccessToken == "" || c.session.isExpiredAt(c.now()) {
+		if err := c.refreshSession(ctx); err != nil {
+			return "", err
+		}
+		if c.sessions != nil {
+			if err := c.sessions.SaveSession(c.session); err != nil {
+				return "", err
+			}
 		}
 	}
-
-	return &StravaClient{
-		session: &session,
-		limiter: rate.NewLimiter(100.0/(60*15), 10),
-	}, nil
+	return c.session.AccessToken, nil
 }
 
-func NewStravaClientFromBrowserBasedLogin(clientId, clientSecret string, store DataStore) (*StravaClient, error) {
+func NewStravaClientFromBrowserBasedLogin(clientId, clientSecret string, store Store) (*StravaClient, error) {
 	port := 9753
 	done := make(chan bool, 1)
 	quit := make(chan os.Signal, 1)
@@ -324,26 +555,101 @@ func NewStravaClientFromBrowserBasedLogin(clientId, clientSecret string, store D
 	if session == nil {
 		return nil, fmt.Errorf("unexpected error: no session found")
 	}
-	return NewStravaClientFromSession(*session)
+	return NewStravaClientFromSession(*session, store)
 }
 
-func (c *StravaClient) httpReq(method string, url string, headers map[string]string, body []byte, expectedStatus int) (*http.Response, error) {
+// RateLimit returns the API quota usage most recently reported by Strava.
+func (c *StravaClient) RateLimit() RateLimitStatus {
+	return c.quota.Status()
+}
+
+const maxAttempts = 5
+
+// ErrUnauthorized means Strava rejected the session, even after refreshing
+// it, e.g. because the athlete revoked access.
+var ErrUnauthorized = errors.New("strava: unauthorized")
+
+// ErrNotFound means Strava has no such object, or it isn't visible.
+var ErrNotFound = errors.New("strava: not found")
+
+// httpReq makes a rate limited request, retrying 429 responses once the rate
+// limit window resets and 5xx responses with backoff, and refreshing the
+// session once if the access token is rejected.
+func (c *StravaClient) httpReq(ctx context.Context, method string, url string, headers map[string]string, body []byte, expectedStatus int) (*http.Response, error) {
+	_, authorized := headers["Authorization"]
+	refreshed := false
+	forceRefresh := false
+
+	for attempt := 1; ; attempt++ {
+		if err := c.quota.wait(ctx); err != nil {
+			return nil, err
+		}
+		resp, err := c.doReq(ctx, method, url, headers, body, forceRefresh)
+		if err != nil {
+			return nil, err
+		}
+		c.quota.update(resp.Header)
+		forceRefresh = false
+
+		if resp.StatusCode == http.StatusUnauthorized && !authorized && !refreshed {
+			// The token may have been revoked or rotated elsewhere; refresh once
+			log.Printf("%s %s ... access token rejected, refreshing session", method, url)
+			refreshed, forceRefresh = true, true
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < maxAttempts {
+			// Backing off won't help until the window resets
+			wait := c.quota.retryAfter()
+			log.Printf("%s %s ... %s, retrying in %s (attempt %d/%d)", method, url, resp.Status, wait.Round(time.Second), attempt, maxAttempts)
+			if err := c.quota.sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode >= 500 && attempt < maxAttempts {
+			wait := retryBackoff(attempt)
+			log.Printf("%s %s ... %s, retrying in %s (attempt %d/%d)", method, url, resp.Status, wait, attempt, maxAttempts)
+			if err := c.quota.sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized {
+			return resp, fmt.Errorf("%s %s: %w", method, url, ErrUnauthorized)
+		}
+		if resp.StatusCode == http.StatusNotFound && expectedStatus != -1 {
+			return resp, fmt.Errorf("%s %s: %w", method, url, ErrNotFound)
+		}
+		if expectedStatus != -1 && resp.StatusCode != expectedStatus {
+			return resp, fmt.Errorf("unexpected status: %d (expected %d)", resp.StatusCode, expectedStatus)
+		}
+
+		return resp, nil
+	}
+}
+
+func (c *StravaClient) doReq(ctx context.Context, method string, url string, headers map[string]string, body []byte, forceRefresh bool) (*http.Response, error) {
 	start := time.Now()
-	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
-	if _, ok := headers["Authorization"]; !ok && c.session.AccessToken != "" {
-		headers["Authorization"] = "Bearer " + c.session.AccessToken
-	}
-
 	for k, v := range headers {
 		req.Header.Add(k, v)
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	if _, ok := headers["Authorization"]; !ok {
+		token, err := c.accessToken(ctx, forceRefresh)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -357,10 +663,6 @@ func (c *StravaClient) httpReq(method string, url string, headers map[string]str
 
 	log.Printf("%s %s ... %s (%s; %s)", req.Method, req.URL.String(), resp.Status, time.Since(start), humanize.Bytes(uint64(len(responseBody))))
 
-	if expectedStatus != -1 && resp.StatusCode != expectedStatus {
-		return resp, fmt.Errorf("unexpected status: %d (expected %d)", resp.StatusCode, expectedStatus)
-	}
-
 	return resp, nil
 }
 
@@ -378,7 +680,7 @@ func apiGetSessionFromAuthorizationCode(clientId, clientSecret, authorizationCod
 
 	req, err := http.NewRequest(
 		http.MethodPost,
-		"https://www.strava.com/api/v3/oauth/token",
+		DefaultBaseURL+"/oauth/token",
 		bytes.NewReader(body),
 	)
 
@@ -411,23 +713,23 @@ func apiGetSessionFromAuthorizationCode(clientId, clientSecret, authorizationCod
 	return &newsession, nil
 }
 
-func (c *StravaClient) apiGetActivities(ctx context.Context, page int, mostRecent time.Time) ([]SummaryActivity, error) {
-	c.limiter.Wait(ctx)
-
-	url := fmt.Sprintf("https://www.strava.com/api/v3/athlete/activities?page=%d&per_page=200", page)
-	if !mostRecent.IsZero() {
-		url = url + fmt.Sprintf("&after=%d", mostRecent.Unix())
+func (c *StravaClient) apiGetActivities(ctx context.Context, page int, after, before time.Time) ([]SummaryActivity, error) {
+	url := fmt.Sprintf("%s/athlete/activities?page=%d&per_page=200", c.baseURL, page)
+	if !after.IsZero() {
+		url = url + fmt.Sprintf("&after=%d", after.Unix())
+	}
+	if !before.IsZero() {
+		url = url + fmt.Sprintf("&before=%d", before.Unix())
 	}
 
 	resp, err := c.httpReq(
+		ctx,
 		"GET",
 		url,
 		map[string]string{},
 		[]byte{},
-		-1,
+		http.StatusOK,
 	)
-	log.Printf("GET %s: %d", url, resp.StatusCode)
-
 	if err != nil {
 		return nil, err
 	}
@@ -445,15 +747,40 @@ func (c *StravaClient) apiGetActivities(ctx context.Context, page int, mostRecen
 	return activities, nil
 }
 
-func (c *StravaClient) apiGetAthlete(ctx context.Context) (*StravaAthlete, error) {
-	c.limiter.Wait(ctx)
+func (c *StravaClient) apiGetActivity(ctx context.Context, activityId int64) (*DetailedActivity, error) {
+	resp, err := c.httpReq(
+		ctx,
+		"GET",
+		fmt.Sprintf("%s/activities/%d", c.baseURL, activityId),
+		map[string]string{},
+		[]byte{},
+		http.StatusOK,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 
+	var activity DetailedActivity
+	err = json.Unmarshal(body, &activity)
+	if err != nil {
+		return nil, err
+	}
+	return &activity, nil
+}
+
+func (c *StravaClient) apiGetAthlete(ctx context.Context) (*StravaAthlete, error) {
 	resp, err := c.httpReq(
+		ctx,
 		"GET",
-		"https://www.strava.com/api/v3/athlete",
+		c.baseURL+"/athlete",
 		map[string]string{},
 		[]byte{},
-		-1,
+		http.StatusOK,
 	)
 
 	if err != nil {
@@ -474,18 +801,15 @@ func (c *StravaClient) apiGetAthlete(ctx context.Context) (*StravaAthlete, error
 }
 
 func (c *StravaClient) apiGetLaps(ctx context.Context, activityId int64) ([]ActivityLap, error) {
-	c.limiter.Wait(ctx)
-
-	url := fmt.Sprintf("https://www.strava.com/api/v3/activities/%d/laps", activityId)
+	url := fmt.Sprintf("%s/activities/%d/laps", c.baseURL, activityId)
 	resp, err := c.httpReq(
+		ctx,
 		"GET",
 		url,
 		map[string]string{},
 		[]byte{},
-		-1,
+		http.StatusOK,
 	)
-	log.Printf("GET %s: %d", url, resp.StatusCode)
-
 	if err != nil {
 		return nil, err
 	}
@@ -503,37 +827,6 @@ func (c *StravaClient) apiGetLaps(ctx context.Context, activityId int64) ([]Acti
 	return laps, nil
 }
 
-func (c *StravaClient) Sync(ctx context.Context, store DataStore) error {
-	mostRecent, err := store.GetMostRecentActivityDate()
-	if err != nil {
-		return err
-	}
-
-	for i := 1; ; i++ {
-		activities, err := c.apiGetActivities(ctx, i, mostRecent)
-		if err != nil {
-			return err
-		}
-		if len(activities) == 0 {
-			break
-		}
-		for _, activity := range activities {
-			laps, err := c.apiGetLaps(ctx, activity.Id)
-			if err != nil {
-				return err
-			}
-			if err := store.SaveLaps(activity.Id, laps); err != nil {
-				return err
-			}
-		}
-		if err := store.Save(activities); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
 // open opens the specified URL in the default browser of the user.
 func open(url string) error {
 	var cmd string
@@ -569,186 +862,3 @@ func createDirectoryIfNotExists(dirName string) error {
 
 	return nil
 }
-
-type DataStore struct {
-	db *sql.DB
-}
-
-func NewPostgresDataStore(dsn string) (DataStore, error) {
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return DataStore{}, err
-	}
-
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS strava_activities (
-			id bigserial primary key,
-			value jsonb
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS strava_activities (
-			id bigserial primary key,
-			start_date timestamptz,
-			value jsonb
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS strava_laps (
-			id bigserial primary key,
-			activity_id text,
-			value jsonb
-		)`,
-
-		`CREATE INDEX IF NOT EXISTS strava_activities_date ON strava_activities (start_date DESC)`,
-	}
-
-	for _, query := range queries {
-		_, err = db.Exec(query)
-		if err != nil {
-			return DataStore{}, err
-		}
-	}
-
-	return DataStore{db}, nil
-}
-
-type ActivityFilter struct {
-	start *time.Time
-	end   *time.Time
-}
-
-func (s *DataStore) GetMostRecentActivityDate() (time.Time, error) {
-	query := `SELECT coalesce(max((value->>'start_date_local')::timestamptz), '1970-01-01T00:00:00Z'::timestamptz) FROM strava_activities`
-	var t time.Time
-	err := s.db.QueryRow(query).Scan(&t)
-	return t, err
-}
-
-func (s *DataStore) GetSession() (*StravaSession, error) {
-	var bytes []byte
-	err := s.db.QueryRow("SELECT value FROM strava_session WHERE id=1").Scan(&bytes)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
-	}
-
-	var session StravaSession
-	if err := json.Unmarshal(bytes, &session); err != nil {
-		return nil, err
-	}
-
-	return &session, nil
-}
-
-func (s *DataStore) SaveSession(session *StravaSession) error {
-	bytes, err := json.Marshal(session)
-	if err != nil {
-		return err
-	}
-
-	query := `INSERT INTO strava_session (id, value)
-		VALUES (1, $1)
-		ON CONFLICT (id)
-		DO UPDATE SET value = EXCLUDED.value`
-	if _, err := s.db.Exec(query, bytes); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (s *DataStore) Save(activities []SummaryActivity) error {
-	query := `INSERT INTO strava_activities (id, start_date, value) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING`
-	for _, activity := range activities {
-		serialized, err := json.Marshal(activity)
-		if err != nil {
-			return err
-		}
-		if _, err := s.db.Exec(query, activity.Id, activity.Date(), serialized); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (s *DataStore) SaveLaps(activityId int64, laps []ActivityLap) error {
-	query := `INSERT INTO strava_laps (id, activity_id, value) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING`
-	for _, lap := range laps {
-		serialized, err := json.Marshal(lap)
-		if err != nil {
-			return err
-		}
-		if _, err := s.db.Exec(query, lap.Id, activityId, serialized); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (s *DataStore) activityQuery(query string) ([]SummaryActivity, error) {
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	activities := []SummaryActivity{}
-	for rows.Next() {
-		var value []byte
-		if err := rows.Scan(&value); err != nil {
-			return nil, err
-		}
-		var activity SummaryActivity
-		if err := json.Unmarshal(value, &activity); err != nil {
-			return nil, err
-		}
-		activities = append(activities, activity)
-	}
-	return activities, nil
-}
-
-func (s *DataStore) LoadPage(page, perPage int) ([]SummaryActivity, error) {
-	return s.activityQuery(
-		fmt.Sprintf(`
-			SELECT value
-			FROM strava_activities
-			ORDER BY (value->>'start_date_local')::timestamptz DESC
-			LIMIT %d
-			OFFSET %d`,
-			perPage,
-			(page-1)*perPage,
-		),
-	)
-}
-
-func (s *DataStore) Load(filters ActivityFilter) ([]SummaryActivity, error) {
-	where := []string{}
-
-	if filters.start != nil {
-		where = append(
-			where,
-			fmt.Sprintf(
-				"(value->>'start_date_local')::timestamptz >= '%s'::timestamptz",
-				filters.start.Format(time.RFC3339),
-			),
-		)
-	}
-
-	if filters.end != nil {
-		where = append(
-			where,
-			fmt.Sprintf(
-				"(value->>'start_date_local')::timestamptz < '%s'::timestamptz",
-				filters.end.Format(time.RFC3339),
-			),
-		)
-	}
-
-	query := "SELECT value FROM strava_activities"
-	if len(where) > 0 {
-		query += fmt.Sprintf(" WHERE %s", strings.Join(where, " AND "))
-	}
-	query += " ORDER BY (value->>'start_date_local')::timestamptz DESC"
-
-	return s.activityQuery(query)
-}
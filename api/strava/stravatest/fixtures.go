@@ -0,0 +1,66 @@
+package stravatest
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+// Run returns a run of the given distance and moving time starting at start,
+// in start's time zone.
+func Run(id int64, name string, start time.Time, miles float64, movingTime time.Duration) strava.SummaryActivity {
+	_, offset := start.Zone()
+	return strava.SummaryActivity{
+		Id:              id,
+		Name:            name,
+		DateString:      start.Format("2006-01-02T15:04:05") + "Z",
+		StartDateString: start.UTC().Format(time.RFC3339),
+		Timezone:        fmt.Sprintf("(GMT%s) %s", start.Format("-07:00"), start.Location()),
+		UtcOffset:       float64(offset),
+		Distance:        miles * strava.MetersPerMile,
+		MovingTime:      movingTime.Seconds(),
+		ElapsedTime:     movingTime.Seconds(),
+		Type:            "Run",
+	}
+}
+
+// Race is a Run marked with Strava's race workout type.
+func Race(id int64, name string, start time.Time, miles float64, movingTime time.Duration) strava.SummaryActivity {
+	activity := Run(id, name, start, miles, movingTime)
+	activity.WorkoutType = 1
+	return activity
+}
+
+// Laps splits an activity into evenly paced one mile laps, with a shorter
+// final lap for any remainder.  Lap ids are derived from the activity id.
+func Laps(activity strava.SummaryActivity) []strava.ActivityLap {
+	start := activity.StartUTC()
+	speed := activity.Distance / activity.MovingTime
+	laps := []strava.ActivityLap{}
+	for remaining, i := activity.Distance, 0; remaining > 1; i++ {
+		distance := strava.MetersPerMile
+		if remaining < distance {
+			distance = remaining
+		}
+		seconds := int32(distance / speed)
+		laps = append(laps, strava.ActivityLap{
+			Id:             activity.Id*1000 + int64(i),
+			ResourceState:  2,
+			Name:           "Lap " + strconv.Itoa(i+1),
+			ElapsedTime:    seconds,
+			MovingTime:     seconds,
+			StartDate:      start,
+			StartDateLocal: start.In(activity.Location()),
+			Distance:       distance,
+			AverageSpeed:   speed,
+			MaxSpeed:       speed,
+			LapIndex:       int32(i + 1),
+			Split:          int32(i + 1),
+		})
+		start = start.Add(time.Duration(seconds) * time.Second)
+		remaining -= distance
+	}
+	return laps
+}
@@ -0,0 +1,353 @@
+// Package stravatest provides an in-process fake of the Strava API for
+// exercising a strava.StravaClient without network access.
+package stravatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scottfrazer/website/strava"
+	"golang.org/x/time/rate"
+)
+
+const (
+	ClientId     = "stravatest-client"
+	ClientSecret = "stravatest-secret"
+)
+
+// Server is a fake Strava API.  It implements token refresh, the athlete
+// activity listing (with after/before/page/per_page), detailed activities,
+// laps and streams, and reports X-RateLimit-* headers like the real API.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	activities   map[int64]strava.SummaryActivity
+	laps         map[int64][]strava.ActivityLap
+	bestEfforts  map[int64][]strava.BestEffort
+	streams      map[int64]*strava.ActivityStreams
+	accessToken  string
+	refreshToken string
+	expiresIn    time.Duration
+	limits       [2]int
+	usage        [2]int
+	failures     []int
+	pathFailures map[string][]int
+	requests     []string
+}
+
+func NewServer() *Server {
+	s := &Server{
+		activities:   map[int64]strava.SummaryActivity{},
+		laps:         map[int64][]strava.ActivityLap{},
+		bestEfforts:  map[int64][]strava.BestEffort{},
+		streams:      map[int64]*strava.ActivityStreams{},
+		refreshToken: uuid.NewString(),
+		expiresIn:    6 * time.Hour,
+		limits:       [2]int{100, 1000},
+		pathFailures: map[string][]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseURL is the value to use for strava.ClientOptions.BaseURL.
+func (s *Server) BaseURL() string {
+	return s.Server.URL + "/api/v3"
+}
+
+// Session returns an expired session holding the server's current refresh
+// token, so the first request made with it refreshes.
+func (s *Server) Session() strava.StravaSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strava.StravaSession{
+		ClientId:     ClientId,
+		ClientSecret: ClientSecret,
+		RefreshToken: s.refreshToken,
+	}
+}
+
+// ClientOptions are the options for an unthrottled client that talks to this
+// server, for tests that also need to set a clock.
+func (s *Server) ClientOptions() strava.ClientOptions {
+	return strava.ClientOptions{
+		BaseURL:    s.BaseURL(),
+		HTTPClient: s.Server.Client(),
+		Limiter:    rate.NewLimiter(rate.Inf, 1),
+	}
+}
+
+// NewClient returns an unthrottled client that talks to this server.
+func (s *Server) NewClient(sessions strava.SessionStore) (*strava.StravaClient, error) {
+	return strava.NewStravaClient(s.Session(), sessions, s.ClientOptions())
+}
+
+// AddActivity adds or replaces an activity and its laps.
+func (s *Server) AddActivity(activity strava.SummaryActivity, laps ...strava.ActivityLap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activities[activity.Id] = activity
+	s.laps[activity.Id] = laps
+}
+
+func (s *Server) DeleteActivity(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.activities, id)
+	delete(s.laps, id)
+	delete(s.bestEfforts, id)
+	delete(s.streams, id)
+}
+
+// SetBestEfforts sets the best efforts included in an activity's details.
+func (s *Server) SetBestEfforts(id int64, efforts ...strava.BestEffort) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bestEfforts[id] = efforts
+}
+
+// SetStreams sets an activity's streams.  Activities without any get a 404
+// from the streams endpoint, as manual activities do.
+func (s *Server) SetStreams(id int64, streams *strava.ActivityStreams) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[id] = streams
+}
+
+// RevokeAccessToken makes the current access token invalid, so the next
+// request gets a 401.
+func (s *Server) RevokeAccessToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accessToken = ""
+}
+
+// SetRateLimit sets the 15-minute and daily limits and usage reported in the
+// X-RateLimit-* headers.  Requests over either limit get a 429.
+func (s *Server) SetRateLimit(shortTermLimit, shortTermUsage, dailyLimit, dailyUsage int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = [2]int{shortTermLimit, dailyLimit}
+	s.usage = [2]int{shortTermUsage, dailyUsage}
+}
+
+// FailNext makes the next len(statuses) API requests fail with the given
+// statuses, e.g. FailNext(429, 503).
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// FailPath makes the next len(statuses) requests for path, e.g.
+// "/activities/1", fail with the given statuses.
+func (s *Server) FailPath(path string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pathFailures[path] = append(s.pathFailures[path], statuses...)
+}
+
+// Requests returns the "METHOD path?query" of every request received, with
+// paths relative to BaseURL.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v3")
+	request := r.Method + " " + path
+	if r.URL.RawQuery != "" {
+		request += "?" + r.URL.RawQuery
+	}
+	s.requests = append(s.requests, request)
+
+	if r.Method == http.MethodPost && path == "/oauth/token" {
+		s.serveToken(w, r)
+		return
+	}
+
+	s.usage[0]++
+	s.usage[1]++
+	w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d,%d", s.limits[0], s.limits[1]))
+	w.Header().Set("X-RateLimit-Usage", fmt.Sprintf("%d,%d", s.usage[0], s.usage[1]))
+
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+	if failures := s.pathFailures[path]; len(failures) > 0 {
+		s.pathFailures[path] = failures[1:]
+		writeError(w, failures[0], http.StatusText(failures[0]))
+		return
+	}
+	if s.usage[0] > s.limits[0] || s.usage[1] > s.limits[1] {
+		writeError(w, http.StatusTooManyRequests, "Rate Limit Exceeded")
+		return
+	}
+	if s.accessToken == "" || r.Header.Get("Authorization") != "Bearer "+s.accessToken {
+		writeError(w, http.StatusUnauthorized, "Authorization Error")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && path == "/athlete":
+		writeJSON(w, http.StatusOK, strava.StravaAthlete{Id: 1, Username: "stravatest"})
+	case r.Method == http.MethodGet && path == "/athlete/activities":
+		s.serveActivities(w, r)
+	case r.Method == http.MethodGet && len(parts) >= 2 && parts[0] == "activities":
+		id, err := strconv.ParseInt(parts[1], 10, 64)
+		activity, ok := s.activities[id]
+		if err != nil || !ok {
+			writeError(w, http.StatusNotFound, "Record Not Found")
+			return
+		}
+		laps := s.laps[id]
+		if laps == nil {
+			laps = []strava.ActivityLap{}
+		}
+		if len(parts) == 3 && parts[2] == "laps" {
+			writeJSON(w, http.StatusOK, laps)
+			return
+		}
+		if len(parts) == 3 && parts[2] == "streams" {
+			s.serveStreams(w, r, id)
+			return
+		}
+		// The detailed activity includes its laps and best efforts
+		activity.Laps = laps
+		writeJSON(w, http.StatusOK, strava.DetailedActivity{
+			SummaryActivity: activity,
+			BestEfforts:     s.bestEfforts[id],
+		})
+	default:
+		writeError(w, http.StatusNotFound, "Record Not Found")
+	}
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request")
+		return
+	}
+	if body["client_id"] != ClientId || body["client_secret"] != ClientSecret ||
+		body["grant_type"] != "refresh_token" || body["refresh_token"] != s.refreshToken {
+		writeError(w, http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	// Like Strava, rotate the refresh token on every refresh
+	s.accessToken = uuid.NewString()
+	s.refreshToken = uuid.NewString()
+	writeJSON(w, http.StatusOK, strava.StravaSession{
+		AccessToken:  s.accessToken,
+		ExpiresAt:    time.Now().Add(s.expiresIn).Unix(),
+		ExpiresIn:    int(s.expiresIn.Seconds()),
+		RefreshToken: s.refreshToken,
+		TokenType:    "Bearer",
+	})
+}
+
+func (s *Server) serveActivities(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	perPage, _ := strconv.Atoi(query.Get("per_page"))
+	after, _ := strconv.ParseInt(query.Get("after"), 10, 64)
+	before, _ := strconv.ParseInt(query.Get("before"), 10, 64)
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = 30
+	}
+
+	activities := []strava.SummaryActivity{}
+	for _, activity := range s.activities {
+		t := activity.StartUTC().Unix()
+		if query.Has("after") && t <= after {
+			continue
+		}
+		if query.Has("before") && t >= before {
+			continue
+		}
+		activities = append(activities, activity)
+	}
+
+	// Strava lists newest first, except when only "after" is given
+	sort.Sort(strava.SummaryActivityDateSort(activities))
+	if !query.Has("after") || query.Has("before") {
+		for i, j := 0, len(activities)-1; i < j; i, j = i+1, j-1 {
+			activities[i], activities[j] = activities[j], activities[i]
+		}
+	}
+
+	start := (page - 1) * perPage
+	if start > len(activities) {
+		start = len(activities)
+	}
+	end := start + perPage
+	if end > len(activities) {
+		end = len(activities)
+	}
+	writeJSON(w, http.StatusOK, activities[start:end])
+}
+
+// serveStreams responds like the streams endpoint with key_by_type=true.
+func (s *Server) serveStreams(w http.ResponseWriter, r *http.Request, id int64) {
+	streams, ok := s.streams[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Record Not Found")
+		return
+	}
+	all := map[string]interface{}{
+		"time":            streams.Time,
+		"latlng":          streams.LatLng,
+		"distance":        streams.Distance,
+		"altitude":        streams.Altitude,
+		"heartrate":       streams.Heartrate,
+		"cadence":         streams.Cadence,
+		"velocity_smooth": streams.Velocity,
+	}
+	response := map[string]interface{}{}
+	for _, key := range strings.Split(r.URL.Query().Get("keys"), ",") {
+		data, ok := all[key]
+		if !ok || reflect.ValueOf(data).Len() == 0 {
+			continue
+		}
+		response[key] = map[string]interface{}{
+			"data":          data,
+			"series_type":   "distance",
+			"original_size": reflect.ValueOf(data).Len(),
+			"resolution":    "high",
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,118 @@
+package strava
+
+import "time"
+
+// Span is a run of consecutive days, from Start through End inclusive.
+// Dates are midnight UTC of the local day, like DayTotals.Date.
+type Span struct {
+	Days  int        `json:"days"`
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+func newSpan(start, end time.Time) Span {
+	return Span{Days: daysBetween(start, end) + 1, Start: &start, End: &end}
+}
+
+// YearConsistency is how many days of a year had an activity.  Days is the
+// number of days in the year, or so far this year.
+type YearConsistency struct {
+	Year          int     `json:"year"`
+	DaysActive    int     `json:"days_active"`
+	Days          int     `json:"days"`
+	PercentActive float64 `json:"percent_active"`
+}
+
+// Consistency summarizes how regularly activities happen.  CurrentStreak
+// is still current if the last active day was yesterday, since today may
+// yet have an activity, and LongestGap is the most days without an activity
+// between two that had one.
+type Consistency struct {
+	CurrentStreak  Span              `json:"current_streak"`
+	LongestStreak  Span              `json:"longest_streak"`
+	LongestGap     Span              `json:"longest_gap"`
+	MinWeeklyRuns  int               `json:"min_weekly_runs"`
+	WeeksAtMinimum int               `json:"weeks_at_minimum"`
+	Weeks          int               `json:"weeks"`
+	Years          []YearConsistency `json:"years"`
+}
+
+// calendarDay is midnight UTC of t's date in t's location.
+func calendarDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func daysBetween(start, end time.Time) int {
+	return int(end.Sub(start).Hours()/24 + 0.5)
+}
+
+// ComputeConsistency works out streaks, gaps, and weekly and yearly
+// consistency as of now from daily totals, as TotalsByDay returns them.
+// Weeks begin on weekStart and count towards WeeksAtMinimum if they have at
+// least minWeeklyRuns activities.  Days are as the athlete saw them, so now
+// should be in the athlete's time zone.
+func ComputeConsistency(totals []DayTotals, minWeeklyRuns int, weekStart time.Weekday, now time.Time) Consistency {
+	consistency := Consistency{MinWeeklyRuns: minWeeklyRuns, Years: []YearConsistency{}}
+	if len(totals) == 0 {
+		return consistency
+	}
+
+	// The active days, in order, and the activities in each week
+	days := []time.Time{}
+	weeks := map[time.Time]int{}
+	for _, day := range totals {
+		days = append(days, day.Date)
+		weeks[weekOf(day.Date, weekStart)] += day.Activities
+	}
+
+	today := calendarDay(now)
+	if today.Before(days[len(days)-1]) {
+		today = days[len(days)-1]
+	}
+
+	streakStart := days[0]
+	for i := 1; i <= len(days); i++ {
+		if i < len(days) && daysBetween(days[i-1], days[i]) == 1 {
+			continue
+		}
+		streak := newSpan(streakStart, days[i-1])
+		if streak.Days > consistency.LongestStreak.Days {
+			consistency.LongestStreak = streak
+		}
+		if i < len(days) {
+			if gap := daysBetween(days[i-1], days[i]) - 1; gap > consistency.LongestGap.Days {
+				consistency.LongestGap = newSpan(days[i-1].AddDate(0, 0, 1), days[i].AddDate(0, 0, -1))
+			}
+			streakStart = days[i]
+		} else if daysBetween(days[i-1], today) <= 1 {
+			consistency.CurrentStreak = streak
+		}
+	}
+
+	for week := weekOf(days[0], weekStart); !week.After(today); week = week.AddDate(0, 0, 7) {
+		consistency.Weeks++
+		if weeks[week] >= minWeeklyRuns {
+			consistency.WeeksAtMinimum++
+		}
+	}
+
+	daysActive := map[int]int{}
+	for _, day := range days {
+		daysActive[day.Year()]++
+	}
+	for year := days[0].Year(); year <= today.Year(); year++ {
+		start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+		end := start.AddDate(1, 0, 0)
+		if end.After(today) {
+			end = today.AddDate(0, 0, 1)
+		}
+		total := daysBetween(start, end)
+		consistency.Years = append(consistency.Years, YearConsistency{
+			Year:          year,
+			DaysActive:    daysActive[year],
+			Days:          total,
+			PercentActive: float64(daysActive[year]) / float64(total) * 100,
+		})
+	}
+	return consistency
+}
@@ -0,0 +1,116 @@
+package strava_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+// dayTotals returns one DayTotals per "YYYY-MM-DD" or "YYYY-MM-DD*n" for n
+// activities.
+func dayTotals(t *testing.T, dates ...string) []strava.DayTotals {
+	totals := []strava.DayTotals{}
+	for _, value := range dates {
+		activities := 1
+		if i := strings.Index(value, "*"); i >= 0 {
+			fmt.Sscan(value[i+1:], &activities)
+			value = value[:i]
+		}
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		day := strava.DayTotals{Date: date}
+		day.Activities = activities
+		totals = append(totals, day)
+	}
+	return totals
+}
+
+func spanString(span strava.Span) string {
+	if span.Days == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%s..%s", span.Start.Format("01-02"), span.End.Format("01-02"))
+}
+
+func TestComputeConsistency(t *testing.T) {
+	tests := []struct {
+		name          string
+		days          []string
+		now           time.Time
+		minWeeklyRuns int
+		weekStart     time.Weekday
+		current       string
+		longest       string
+		gap           string
+		weeks         string
+		years         string
+	}{
+		{
+			name: "no activities", now: time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC),
+			minWeeklyRuns: 3, weekStart: time.Monday,
+			current: "none", longest: "none", gap: "none", weeks: "0/0", years: "",
+		},
+		{
+			name:          "streak through yesterday",
+			days:          []string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-06", "2024-01-07"},
+			now:           time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC),
+			minWeeklyRuns: 3, weekStart: time.Monday,
+			current: "01-06..01-07", longest: "01-01..01-03", gap: "01-04..01-05", weeks: "1/2", years: "2024:5/8",
+		},
+		{
+			name:          "streak broken",
+			days:          []string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-06", "2024-01-07"},
+			now:           time.Date(2024, 1, 9, 10, 0, 0, 0, time.UTC),
+			minWeeklyRuns: 3, weekStart: time.Monday,
+			current: "none", longest: "01-01..01-03", gap: "01-04..01-05", weeks: "1/2", years: "2024:5/9",
+		},
+		{
+			name:          "weeks starting monday",
+			days:          []string{"2024-01-06*2", "2024-01-07"},
+			now:           time.Date(2024, 1, 7, 10, 0, 0, 0, time.UTC),
+			minWeeklyRuns: 2, weekStart: time.Monday,
+			current: "01-06..01-07", longest: "01-06..01-07", gap: "none", weeks: "1/1", years: "2024:2/7",
+		},
+		{
+			name:          "weeks starting sunday",
+			days:          []string{"2024-01-06*2", "2024-01-07"},
+			now:           time.Date(2024, 1, 7, 10, 0, 0, 0, time.UTC),
+			minWeeklyRuns: 2, weekStart: time.Sunday,
+			current: "01-06..01-07", longest: "01-06..01-07", gap: "none", weeks: "1/2", years: "2024:2/7",
+		},
+		{
+			name:          "across a new year",
+			days:          []string{"2023-12-31", "2024-01-01"},
+			now:           time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+			minWeeklyRuns: 1, weekStart: time.Monday,
+			current: "12-31..01-01", longest: "12-31..01-01", gap: "none", weeks: "2/2", years: "2023:1/365 2024:1/1",
+		},
+		{
+			name:          "activity after now",
+			days:          []string{"2024-01-02"},
+			now:           time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC),
+			minWeeklyRuns: 1, weekStart: time.Monday,
+			current: "01-02..01-02", longest: "01-02..01-02", gap: "none", weeks: "1/1", years: "2024:1/2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := strava.ComputeConsistency(dayTotals(t, test.days...), test.minWeeklyRuns, test.weekStart, test.now)
+			years := []string{}
+			for _, year := range c.Years {
+				years = append(years, fmt.Sprintf("%d:%d/%d", year.Year, year.DaysActive, year.Days))
+			}
+			got := []string{spanString(c.CurrentStreak), spanString(c.LongestStreak), spanString(c.LongestGap),
+				fmt.Sprintf("%d/%d", c.WeeksAtMinimum, c.Weeks), strings.Join(years, " ")}
+			want := []string{test.current, test.longest, test.gap, test.weeks, test.years}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package strava
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// ActivityStreams are an activity's recorded samples.
+type ActivityStreams struct {
+	Time      []float64 `json:"time,omitempty"`
+	LatLng    []LatLng  `json:"latlng,omitempty"`
+	Distance  []float64 `json:"distance,omitempty"`
+	Altitude  []float64 `json:"altitude,omitempty"`
+	Heartrate []float64 `json:"heartrate,omitempty"`
+	Cadence   []float64 `json:"cadence,omitempty"`
+	Velocity  []float64 `json:"velocity,omitempty"`
+}
+
+// StreamKeys are the Strava stream types that are fetched.
+var StreamKeys = []string{"time", "latlng", "distance", "altitude", "heartrate", "cadence", "velocity_smooth"}
+
+// Len is the number of samples.
+func (s *ActivityStreams) Len() int {
+	n := len(s.LatLng)
+	for _, values := range s.scalars() {
+		if len(*values.values) > n {
+			n = len(*values.values)
+		}
+	}
+	return n
+}
+
+type scalarStream struct {
+	kind   byte
+	scale  float64
+	values *[]float64
+}
+
+// scalars lists the single value streams with their tag and precision.
+func (s *ActivityStreams) scalars() []scalarStream {
+	return []scalarStream{
+		{1, 1, &s.Time},
+		{3, 10, &s.Distance},
+		{4, 10, &s.Altitude},
+		{5, 1, &s.Heartrate},
+		{6, 1, &s.Cadence},
+		{7, 100, &s.Velocity},
+	}
+}
+
+// The latlng stream's tag and precision, about 10cm
+const (
+	latLngStream = 2
+	latLngScale  = 1e6
+)
+
+const streamsEncodingVersion = 1
+
+var ErrInvalidStreams = errors.New("invalid streams encoding")
+
+// Encode packs the streams as varint deltas for storage.
+func (s *ActivityStreams) Encode() []byte {
+	buf := []byte{streamsEncodingVersion}
+	putDeltas := func(previous *int64, value float64, scale float64) {
+		scaled := int64(math.Round(value * scale))
+		buf = binary.AppendVarint(buf, scaled-*previous)
+		*previous = scaled
+	}
+	for _, stream := range s.scalars() {
+		values := *stream.values
+		if len(values) == 0 {
+			continue
+		}
+		buf = append(buf, stream.kind)
+		buf = binary.AppendUvarint(buf, uint64(len(values)))
+		previous := int64(0)
+		for _, value := range values {
+			putDeltas(&previous, value, stream.scale)
+		}
+	}
+	if len(s.LatLng) > 0 {
+		buf = append(buf, latLngStream)
+		buf = binary.AppendUvarint(buf, uint64(len(s.LatLng)))
+		lat, lng := int64(0), int64(0)
+		for _, p := range s.LatLng {
+			putDeltas(&lat, p.Lat(), latLngScale)
+			putDeltas(&lng, p.Lng(), latLngScale)
+		}
+	}
+	return buf
+}
+
+// DecodeStreams unpacks streams packed by Encode.
+func DecodeStreams(data []byte) (*ActivityStreams, error) {
+	if len(data) == 0 || data[0] != streamsEncodingVersion {
+		return nil, ErrInvalidStreams
+	}
+	data = data[1:]
+	next := func(previous *int64, scale float64) (float64, bool) {
+		delta, n := binary.Varint(data)
+		if n <= 0 {
+			return 0, false
+		}
+		data = data[n:]
+		*previous += delta
+		return float64(*previous) / scale, true
+	}
+
+	streams := &ActivityStreams{}
+	scalars := map[byte]scalarStream{}
+	for _, stream := range streams.scalars() {
+		scalars[stream.kind] = stream
+	}
+	for len(data) > 0 {
+		kind := data[0]
+		count, n := binary.Uvarint(data[1:])
+		if n <= 0 || count > uint64(len(data)) {
+			return nil, ErrInvalidStreams
+		}
+		data = data[1+n:]
+
+		if kind == latLngStream {
+			points := make([]LatLng, count)
+			lat, lng := int64(0), int64(0)
+			for i := range points {
+				var ok1, ok2 bool
+				points[i][0], ok1 = next(&lat, latLngScale)
+				points[i][1], ok2 = next(&lng, latLngScale)
+				if !ok1 || !ok2 {
+					return nil, ErrInvalidStreams
+				}
+			}
+			streams.LatLng = points
+			continue
+		}
+
+		stream, ok := scalars[kind]
+		if !ok {
+			return nil, ErrInvalidStreams
+		}
+		values := make([]float64, count)
+		previous := int64(0)
+		for i := range values {
+			if values[i], ok = next(&previous, stream.scale); !ok {
+				return nil, ErrInvalidStreams
+			}
+		}
+		*stream.values = values
+	}
+	return streams, nil
+}
+
+// Downsample returns at most n evenly spaced samples; n must be at least 2.
+func (s *ActivityStreams) Downsample(n int) *ActivityStreams {
+	length := s.Len()
+	if length <= n {
+		return s
+	}
+	indices := make([]int, n)
+	for i := range indices {
+		indices[i] = int(math.Round(float64(i) * float64(length-1) / float64(n-1)))
+	}
+
+	sampled := &ActivityStreams{}
+	pick := func(values []float64) []float64 {
+		if len(values) != length {
+			return values
+		}
+		result := make([]float64, n)
+		for i, index := range indices {
+			result[i] = values[index]
+		}
+		return result
+	}
+	sampledScalars := sampled.scalars()
+	for i, stream := range s.scalars() {
+		*sampledScalars[i].values = pick(*stream.values)
+	}
+	sampled.LatLng = s.LatLng
+	if len(s.LatLng) == length {
+		sampled.LatLng = make([]LatLng, n)
+		for i, index := range indices {
+			sampled.LatLng[i] = s.LatLng[index]
+		}
+	}
+	return sampled
+}
+
+func (c *StravaClient) apiGetStreams(ctx context.Context, activityId int64) (*ActivityStreams, error) {
+	url := fmt.Sprintf("%s/activities/%d/streams?keys=%s&key_by_type=true", c.baseURL, activityId, strings.Join(StreamKeys, ","))
+	resp, err := c.httpReq(
+		ctx,
+		"GET",
+		url,
+		map[string]string{},
+		[]byte{},
+		-1,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// Activities without any streams, like manual ones, have none to fetch
+	if resp.StatusCode == http.StatusNotFound {
+		return &ActivityStreams{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d (expected %d)", resp.StatusCode, http.StatusOK)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Time      struct{ Data []float64 } `json:"time"`
+		LatLng    struct{ Data []LatLng }  `json:"latlng"`
+		Distance  struct{ Data []float64 } `json:"distance"`
+		Altitude  struct{ Data []float64 } `json:"altitude"`
+		Heartrate struct{ Data []float64 } `json:"heartrate"`
+		Cadence   struct{ Data []float64 } `json:"cadence"`
+		Velocity  struct{ Data []float64 } `json:"velocity_smooth"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return &ActivityStreams{
+		Time:      response.Time.Data,
+		LatLng:    response.LatLng.Data,
+		Distance:  response.Distance.Data,
+		Altitude:  response.Altitude.Data,
+		Heartrate: response.Heartrate.Data,
+		Cadence:   response.Cadence.Data,
+		Velocity:  response.Velocity.Data,
+	}, nil
+}
+
+// Streams returns an activity's streams, fetching them if they aren't stored.
+func (c *StravaClient) Streams(ctx context.Context, store Store, activityId int64) (*ActivityStreams, error) {
+	streams, err := store.LoadStreams(activityId)
+	if err != nil || streams != nil {
+		return streams, err
+	}
+	streams, err = c.apiGetStreams(ctx, activityId)
+	if err != nil {
+		return nil, err
+	}
+	return streams, store.SaveStreams(activityId, streams)
+}
+
+// Syncs stop fetching streams past this fraction of the rate limits
+const streamsQuotaFraction = 0.5
+
+// refreshStreams fetches streams if the quota allows, logging any failure.
+func (c *StravaClient) refreshStreams(ctx context.Context, store Store, activityId int64) error {
+	if !c.quota.below(streamsQuotaFraction) {
+		return store.DeleteStreams(activityId)
+	}
+	streams, err := c.apiGetStreams(ctx, activityId)
+	if err != nil {
+		log.Printf("strava: activity %d: fetching streams: %v", activityId, err)
+		return store.DeleteStreams(activityId)
+	}
+	return store.SaveStreams(activityId, streams)
+}
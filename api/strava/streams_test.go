@@ -0,0 +1,92 @@
+package strava_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+func TestStreamsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams *strava.ActivityStreams
+	}{
+		{"empty", &strava.ActivityStreams{}},
+		{"run", &strava.ActivityStreams{
+			Time:      []float64{0, 1, 2, 5},
+			LatLng:    []strava.LatLng{{38.5, -120.2}, {38.500012, -120.200034}, {38.5, -120.2}, {-33.9, 151.2}},
+			Distance:  []float64{0, 3.2, 6.5, 15.1},
+			Altitude:  []float64{12.3, 12.1, -4.5, 2000.7},
+			Heartrate: []float64{120, 121, 119, 180},
+			Cadence:   []float64{80, 82, 0, 90},
+			Velocity:  []float64{3.21, 3.3, 0, 2.95},
+		}},
+		{"heart rate only", &strava.ActivityStreams{Time: []float64{0, 1}, Heartrate: []float64{60, 61}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := strava.DecodeStreams(test.streams.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%+v", decoded) != fmt.Sprintf("%+v", test.streams) {
+				t.Errorf("got %+v\nwant %+v", decoded, test.streams)
+			}
+		})
+	}
+}
+
+func TestDecodeStreamsRejectsInvalidData(t *testing.T) {
+	encoded := (&strava.ActivityStreams{Time: []float64{0, 1, 2}}).Encode()
+	tests := map[string][]byte{
+		"empty":          nil,
+		"wrong version":  append([]byte{9}, encoded[1:]...),
+		"truncated":      encoded[:len(encoded)-1],
+		"unknown stream": {encoded[0], 99, 1, 0},
+		"too long":       {encoded[0], 1, 200, 0},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := strava.DecodeStreams(data); !errors.Is(err, strava.ErrInvalidStreams) {
+				t.Errorf("err = %v, want %v", err, strava.ErrInvalidStreams)
+			}
+		})
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	streams := &strava.ActivityStreams{
+		Time:      []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		LatLng:    []strava.LatLng{{0, 0}, {0, 1}, {0, 2}, {0, 3}, {0, 4}, {0, 5}, {0, 6}, {0, 7}, {0, 8}, {0, 9}, {0, 10}},
+		Heartrate: []float64{100, 101, 102, 103, 104, 105, 106, 107, 108, 109, 110},
+		// Shorter than the others, so left alone
+		Cadence: []float64{80, 81},
+	}
+	tests := []struct {
+		n         int
+		time      string
+		latLng    string
+		heartrate string
+	}{
+		{2, "[0 10]", "[[0 0] [0 10]]", "[100 110]"},
+		{3, "[0 5 10]", "[[0 0] [0 5] [0 10]]", "[100 105 110]"},
+		{4, "[0 3 7 10]", "[[0 0] [0 3] [0 7] [0 10]]", "[100 103 107 110]"},
+		{11, "[0 1 2 3 4 5 6 7 8 9 10]", "[[0 0] [0 1] [0 2] [0 3] [0 4] [0 5] [0 6] [0 7] [0 8] [0 9] [0 10]]", "[100 101 102 103 104 105 106 107 108 109 110]"},
+		{50, "[0 1 2 3 4 5 6 7 8 9 10]", "[[0 0] [0 1] [0 2] [0 3] [0 4] [0 5] [0 6] [0 7] [0 8] [0 9] [0 10]]", "[100 101 102 103 104 105 106 107 108 109 110]"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.n), func(t *testing.T) {
+			sampled := streams.Downsample(test.n)
+			got := []string{fmt.Sprint(sampled.Time), fmt.Sprint(sampled.LatLng), fmt.Sprint(sampled.Heartrate), fmt.Sprint(sampled.Cadence)}
+			want := []string{test.time, test.latLng, test.heartrate, "[80 81]"}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+	if streams.Len() != 11 {
+		t.Errorf("Downsample changed the original streams: %+v", streams)
+	}
+}
@@ -0,0 +1,251 @@
+package strava
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+type SyncMode string
+
+const (
+	// SyncIncremental fetches activities newer than the most recent stored one
+	SyncIncremental SyncMode = "incremental"
+	// SyncFull walks every activity and deletes those removed upstream
+	SyncFull SyncMode = "full"
+	// SyncBackfill fetches every activity that started in [Start, End)
+	SyncBackfill SyncMode = "backfill"
+)
+
+// SyncOptions selects which activities a sync fetches.  Start and End are
+// only used by SyncBackfill.
+type SyncOptions struct {
+	Mode  SyncMode  `json:"mode"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (o SyncOptions) Validate() error {
+	switch o.Mode {
+	case SyncIncremental, SyncFull:
+		return nil
+	case SyncBackfill:
+		if o.Start.IsZero() || o.End.IsZero() {
+			return fmt.Errorf("backfill requires start and end")
+		}
+		if !o.Start.Before(o.End) {
+			return fmt.Errorf("backfill start must be before end")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown sync mode: %q", o.Mode)
+	}
+}
+
+// SyncStats counts what a sync fetched and changed.
+type SyncStats struct {
+	Pages              int `json:"pages"`
+	ActivitiesInserted int `json:"activities_inserted"`
+	ActivitiesUpdated  int `json:"activities_updated"`
+	ActivitiesDeleted  int `json:"activities_deleted"`
+	LapsInserted       int `json:"laps_inserted"`
+	LapsUpdated        int `json:"laps_updated"`
+}
+
+// SyncRun is the recorded history of a single sync.
+type SyncRun struct {
+	Id         int64      `json:"id"`
+	Mode       SyncMode   `json:"mode"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	SyncStats
+	Error string `json:"error,omitempty"`
+}
+
+// SyncCheckpoint records the last page completed by an in-progress sync so
+// an interrupted sync resumes where it left off instead of starting over.
+type SyncCheckpoint struct {
+	After     time.Time
+	Before    time.Time
+	Page      int
+	StartedAt time.Time
+}
+
+func (c *StravaClient) Sync(ctx context.Context, store Store) error {
+	_, err := c.SyncWithOptions(ctx, store, SyncOptions{Mode: SyncIncremental})
+	return err
+}
+
+// Reconcile walks every activity on Strava, upserting any that changed and
+// deleting local activities that no longer exist upstream.
+func (c *StravaClient) Reconcile(ctx context.Context, store Store) error {
+	_, err := c.SyncWithOptions(ctx, store, SyncOptions{Mode: SyncFull})
+	return err
+}
+
+// SyncWithOptions runs a sync and records it in the sync history.
+func (c *StravaClient) SyncWithOptions(ctx context.Context, store Store, opts SyncOptions) (SyncStats, error) {
+	if err := opts.Validate(); err != nil {
+		return SyncStats{}, err
+	}
+
+	id, err := store.StartSyncRun(opts.Mode)
+	if err != nil {
+		return SyncStats{}, err
+	}
+	stats := SyncStats{}
+	err = c.sync(ctx, store, opts, &stats)
+	if recordErr := store.FinishSyncRun(id, stats, err); recordErr != nil {
+		log.Printf("strava: recording sync run %d: %v", id, recordErr)
+	}
+	return stats, err
+}
+
+func (c *StravaClient) sync(ctx context.Context, store Store, opts SyncOptions, stats *SyncStats) error {
+	var after, before time.Time
+	switch opts.Mode {
+	case SyncIncremental:
+		mostRecent, err := store.GetMostRecentActivityDate()
+		if err != nil {
+			return err
+		}
+		after = mostRecent
+	case SyncBackfill:
+		after, before = opts.Start, opts.End
+	}
+
+	checkpoint, err := store.GetSyncCheckpoint(opts.Mode)
+	if err != nil {
+		return err
+	}
+
+	// An incremental sync always resumes with its original cutoff, since the
+	// most recent activity date moves as pages are saved.  Other modes only
+	// resume a checkpoint for the same range.
+	resume := checkpoint != nil &&
+		(opts.Mode == SyncIncremental || (checkpoint.After.Equal(after) && checkpoint.Before.Equal(before)))
+	if resume {
+		log.Printf("strava: resuming %s sync after page %d", opts.Mode, checkpoint.Page)
+		after, before = checkpoint.After, checkpoint.Before
+	} else {
+		checkpoint, err = store.StartSyncCheckpoint(opts.Mode, after, before)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Finish activities that an earlier sync saved but failed to fetch the
+	// details of, which won't otherwise be seen as changed again
+	if err := c.savePendingDetails(ctx, store, stats); err != nil {
+		return err
+	}
+
+	for page := checkpoint.Page + 1; ; page++ {
+		activities, err := c.apiGetActivities(ctx, page, after, before)
+		if err != nil {
+			return err
+		}
+		if len(activities) == 0 {
+			break
+		}
+		if err := c.saveActivities(ctx, store, activities, stats); err != nil {
+			return err
+		}
+		if err := store.SaveSyncCheckpoint(opts.Mode, page); err != nil {
+			return err
+		}
+		stats.Pages++
+	}
+
+	// Every activity seen by a full sync has had synced_at bumped, so anything
+	// older than the start of the sync is gone from Strava
+	if opts.Mode == SyncFull {
+		deleted, err := store.DeleteSyncedBefore(checkpoint.StartedAt)
+		if err != nil {
+			return err
+		}
+		stats.ActivitiesDeleted += deleted
+
+		// Rebuilding records here also fills them in for activities that
+		// were synced before records were kept
+		if err := RecomputeRecords(store); err != nil {
+			return err
+		}
+	}
+
+	return store.ClearSyncCheckpoint(opts.Mode)
+}
+
+// saveActivities upserts activities and then fetches the details of those
+// that are new or changed.
+func (c *StravaClient) saveActivities(ctx context.Context, store Store, activities []SummaryActivity, stats *SyncStats) error {
+	inserted, updated, err := store.Save(activities)
+	if err != nil {
+		return err
+	}
+	stats.ActivitiesInserted += len(inserted)
+	stats.ActivitiesUpdated += len(updated)
+	return c.savePendingDetails(ctx, store, stats)
+}
+
+// savePendingDetails fetches the details of every activity whose details
+// are pending, for their laps and record efforts, and their streams if the
+// rate limits allow.  Saved activities stay pending until their details are
+// saved, so any left by a failed sync are retried by the next one.
+func (c *StravaClient) savePendingDetails(ctx context.Context, store Store, stats *SyncStats) error {
+	pending, err := store.PendingDetails()
+	if err != nil {
+		return err
+	}
+	for _, id := range pending {
+		detail, err := c.apiGetActivity(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since it was listed
+			log.Printf("strava: activity %d was deleted upstream", id)
+			if err := store.Delete(id); err != nil {
+				return err
+			}
+			stats.ActivitiesDeleted++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		activity, err := store.LoadActivity(id)
+		if err != nil {
+			return err
+		}
+		if activity == nil {
+			continue
+		}
+		lapsInserted, lapsUpdated, err := saveDetails(store, *activity, detail)
+		if err != nil {
+			return err
+		}
+		stats.LapsInserted += lapsInserted
+		stats.LapsUpdated += lapsUpdated
+		if err := c.refreshStreams(ctx, store, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveDetails saves the laps and record efforts of an already saved activity
+// from its details, and records that its details are no longer pending.
+func saveDetails(store Store, activity SummaryActivity, detail *DetailedActivity) (lapsInserted int, lapsUpdated int, err error) {
+	laps := detail.Laps
+	if laps == nil {
+		laps = []ActivityLap{}
+	}
+	lapsInserted, lapsUpdated, err = store.SaveLaps(activity.Id, laps)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := updateEfforts(store, activity, laps, detail.BestEfforts); err != nil {
+		return 0, 0, err
+	}
+	return lapsInserted, lapsUpdated, store.DetailsSaved(activity.Id)
+}
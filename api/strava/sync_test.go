@@ -0,0 +1,255 @@
+package strava_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottfrazer/website/strava"
+	"github.com/scottfrazer/website/strava/stravatest"
+)
+
+// forEachStore runs test against a fresh memory store and SQLite store.
+func forEachStore(t *testing.T, test func(t *testing.T, store strava.Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, strava.NewMemoryStore())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := strava.NewSQLiteStore(filepath.Join(t.TempDir(), "strava.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, store)
+	})
+}
+
+// newServer returns a fake Strava with n daily runs, the newest with the
+// highest id, and a rate limit high enough not to get in the way.
+func newServer(t *testing.T, n int) *stravatest.Server {
+	server := stravatest.NewServer()
+	t.Cleanup(server.Close)
+	server.SetRateLimit(100000, 0, 1000000, 0)
+	start := time.Date(2023, 1, 1, 7, 0, 0, 0, time.UTC)
+	for i := 1; i <= n; i++ {
+		run := stravatest.Run(int64(i), fmt.Sprintf("Run %d", i), start.AddDate(0, 0, i), 3, 24*time.Minute)
+		server.AddActivity(run, stravatest.Laps(run)...)
+	}
+	return server
+}
+
+func newClient(t *testing.T, server *stravatest.Server, store strava.Store) *strava.StravaClient {
+	client, err := server.NewClient(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// requests returns the requests made since skip whose "METHOD path" starts
+// with prefix.
+func requests(server *stravatest.Server, skip int, prefix string) []string {
+	matching := []string{}
+	for _, request := range server.Requests()[skip:] {
+		if strings.HasPrefix(request, prefix) {
+			matching = append(matching, request)
+		}
+	}
+	return matching
+}
+
+func countActivities(t *testing.T, store strava.Store) int {
+	count, err := store.Count(strava.ActivityFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func pendingDetails(t *testing.T, store strava.Store) []int64 {
+	pending, err := store.PendingDetails()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending
+}
+
+func TestSyncPaginates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store strava.Store) {
+		server := newServer(t, 450)
+		client := newClient(t, server, store)
+
+		stats, err := client.SyncWithOptions(context.Background(), store, strava.SyncOptions{Mode: strava.SyncFull})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Pages != 3 || stats.ActivitiesInserted != 450 {
+			t.Errorf("stats = %+v, want 3 pages and 450 inserted", stats)
+		}
+		if n := countActivities(t, store); n != 450 {
+			t.Errorf("stored %d activities, want 450", n)
+		}
+		if pending := pendingDetails(t, store); len(pending) != 0 {
+			t.Errorf("details pending for %v", pending)
+		}
+		laps, err := store.LoadLaps(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(laps) != 3 {
+			t.Errorf("activity 1 has %d laps, want 3", len(laps))
+		}
+		if pages := requests(server, 0, "GET /athlete/activities"); len(pages) != 4 {
+			t.Errorf("listed %d pages, want 4 including the empty one: %v", len(pages), pages)
+		}
+	})
+}
+
+func TestSyncResumesFromCheckpoint(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store strava.Store) {
+		server := newServer(t, 450)
+		client := newClient(t, server, store)
+		ctx := context.Background()
+
+		// Listed newest first, so activity 250 is the first on page 2
+		server.FailPath("/activities/250", http.StatusBadRequest)
+		if _, err := client.SyncWithOptions(ctx, store, strava.SyncOptions{Mode: strava.SyncFull}); err == nil {
+			t.Fatal("sync succeeded despite a failed detail request")
+		}
+		checkpoint, err := store.GetSyncCheckpoint(strava.SyncFull)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint == nil || checkpoint.Page != 1 {
+			t.Fatalf("checkpoint = %+v, want page 1", checkpoint)
+		}
+
+		skip := len(server.Requests())
+		stats, err := client.SyncWithOptions(ctx, store, strava.SyncOptions{Mode: strava.SyncFull})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := requests(server, skip, "GET /athlete/activities")
+		if len(pages) == 0 || !strings.Contains(pages[0], "page=2&") {
+			t.Errorf("resumed sync listed %v, want to start at page 2", pages)
+		}
+		if details := requests(server, skip, "GET /activities/450"); len(details) != 0 {
+			t.Errorf("resumed sync refetched page 1: %v", details)
+		}
+		if stats.ActivitiesDeleted != 0 {
+			t.Errorf("resumed sync deleted %d activities", stats.ActivitiesDeleted)
+		}
+		if n := countActivities(t, store); n != 450 {
+			t.Errorf("stored %d activities, want 450", n)
+		}
+		if checkpoint, err := store.GetSyncCheckpoint(strava.SyncFull); err != nil || checkpoint != nil {
+			t.Errorf("checkpoint = %+v, %v after a complete sync", checkpoint, err)
+		}
+	})
+}
+
+func TestSyncRetriesFailedDetails(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store strava.Store) {
+		server := newServer(t, 3)
+		client := newClient(t, server, store)
+		ctx := context.Background()
+
+		server.FailPath("/activities/2", http.StatusBadRequest)
+		if err := client.Sync(ctx, store); err == nil {
+			t.Fatal("sync succeeded despite a failed detail request")
+		}
+		if pending := pendingDetails(t, store); len(pending) == 0 {
+			t.Fatal("no details pending after a failed detail request")
+		}
+
+		// Nothing changed upstream, so only the pending details bring the
+		// laps in
+		if err := client.Sync(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+		if pending := pendingDetails(t, store); len(pending) != 0 {
+			t.Errorf("details still pending for %v", pending)
+		}
+		laps, err := store.LoadLaps(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(laps) != 3 {
+			t.Errorf("activity 2 has %d laps, want 3", len(laps))
+		}
+	})
+}
+
+func TestSyncUpdatesAndDeletes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store strava.Store) {
+		server := newServer(t, 3)
+		client := newClient(t, server, store)
+		ctx := context.Background()
+		if err := client.Reconcile(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+
+		renamed := stravatest.Run(2, "Renamed", time.Date(2023, 1, 3, 7, 0, 0, 0, time.UTC), 3, 24*time.Minute)
+		server.AddActivity(renamed, stravatest.Laps(renamed)...)
+		server.DeleteActivity(3)
+
+		skip := len(server.Requests())
+		stats, err := client.SyncWithOptions(ctx, store, strava.SyncOptions{Mode: strava.SyncFull})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.ActivitiesInserted != 0 || stats.ActivitiesUpdated != 1 || stats.ActivitiesDeleted != 1 {
+			t.Errorf("stats = %+v, want 1 updated and 1 deleted", stats)
+		}
+		if details := requests(server, skip, "GET /activities/"); len(details) != 2 {
+			// The changed activity's details and streams
+			t.Errorf("fetched %v, want only the renamed activity", details)
+		}
+
+		activity, err := store.LoadActivity(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if activity == nil || activity.Name != "Renamed" {
+			t.Errorf("activity 2 = %+v, want it renamed", activity)
+		}
+		if activity, err := store.LoadActivity(3); err != nil || activity != nil {
+			t.Errorf("activity 3 = %+v, %v, want it deleted", activity, err)
+		}
+	})
+}
+
+func TestSyncWaitsForRateLimitReset(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store strava.Store) {
+		server := newServer(t, 1)
+		now := time.Date(2024, 3, 1, 10, 5, 0, 0, time.UTC)
+		var slept []time.Duration
+		opts := server.ClientOptions()
+		opts.Now = func() time.Time { return now }
+		opts.Sleep = func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			now = now.Add(d)
+			return nil
+		}
+		client, err := strava.NewStravaClient(server.Session(), store, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server.FailNext(http.StatusTooManyRequests, http.StatusTooManyRequests)
+		if err := client.Sync(context.Background(), store); err != nil {
+			t.Fatal(err)
+		}
+		// Each 429 waits for the next 15 minute window
+		want := []time.Duration{10 * time.Minute, 15 * time.Minute}
+		if fmt.Sprint(slept) != fmt.Sprint(want) {
+			t.Errorf("slept %v, want %v", slept, want)
+		}
+		if n := countActivities(t, store); n != 1 {
+			t.Errorf("stored %d activities, want 1", n)
+		}
+	})
+}
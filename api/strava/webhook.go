@@ -0,0 +1,99 @@
+package strava
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// WebhookEvent is the body Strava POSTs to a push subscription callback.
+// See https://developers.strava.com/docs/webhooks/
+type WebhookEvent struct {
+	ObjectType     string            `json:"object_type"`
+	ObjectId       int64             `json:"object_id"`
+	AspectType     string            `json:"aspect_type"`
+	Updates        map[string]string `json:"updates"`
+	OwnerId        int64             `json:"owner_id"`
+	SubscriptionId int64             `json:"subscription_id"`
+	EventTime      int64             `json:"event_time"`
+}
+
+func (e WebhookEvent) IsDeauthorization() bool {
+	return e.ObjectType == "athlete" && e.AspectType == "update" && e.Updates["authorized"] == "false"
+}
+
+// IsFrom reports whether the event was sent for the given subscription and
+// athlete.  Neither is secret, so events are still checked against Strava
+// before anything is deleted.
+func (e WebhookEvent) IsFrom(subscriptionId, ownerId int64) bool {
+	return e.SubscriptionId == subscriptionId && e.OwnerId == ownerId
+}
+
+// HandleEvent applies a single webhook event to the store.  Activity creates
+// and updates re-fetch the activity with its laps and record efforts.
+// Deletes and deauthorizations are only acted on once Strava confirms them,
+// by no longer returning the activity or accepting the session.
+func (s *SyncScheduler) HandleEvent(ctx context.Context, event WebhookEvent) error {
+	log.Printf("strava webhook: %s %s %d", event.AspectType, event.ObjectType, event.ObjectId)
+
+	if event.IsDeauthorization() {
+		client, err := s.Client()
+		if err != nil {
+			return err
+		}
+		if _, err := client.apiGetAthlete(ctx); err == nil {
+			log.Printf("strava webhook: ignoring deauthorization, the session is still accepted")
+			return nil
+		} else if !errors.Is(err, ErrUnauthorized) {
+			return err
+		}
+		s.Disconnect()
+		return s.store.DeleteSession()
+	}
+
+	if event.ObjectType != "activity" {
+		return nil
+	}
+
+	switch event.AspectType {
+	case "create", "update":
+		client, err := s.Client()
+		if err != nil {
+			return err
+		}
+		detail, err := client.apiGetActivity(ctx, event.ObjectId)
+		if err != nil {
+			return err
+		}
+		// Store the same document a sync would, which has no laps
+		activity := detail.SummaryActivity
+		activity.Laps = nil
+		if _, _, err := s.store.Save([]SummaryActivity{activity}); err != nil {
+			return err
+		}
+		if _, _, err := saveDetails(s.store, activity, detail); err != nil {
+			return err
+		}
+		s.activitiesChanged()
+		return client.refreshStreams(ctx, s.store, activity.Id)
+	case "delete":
+		client, err := s.Client()
+		if err != nil {
+			return err
+		}
+		if _, err := client.apiGetActivity(ctx, event.ObjectId); err == nil {
+			log.Printf("strava webhook: ignoring delete, activity %d still exists", event.ObjectId)
+			return nil
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if err := s.store.Delete(event.ObjectId); err != nil {
+			return err
+		}
+		s.activitiesChanged()
+		return nil
+	default:
+		return fmt.Errorf("unknown aspect_type: %s", event.AspectType)
+	}
+}
@@ -0,0 +1,282 @@
+package strava
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// What heart rate zone boundaries are percentages of
+const (
+	HeartrateBasisMax       = "max"
+	HeartrateBasisThreshold = "lthr"
+)
+
+// Default zone boundaries, as percentages of the basis
+var (
+	DefaultMaxHeartrateZones       = []float64{60, 70, 80, 90}
+	DefaultThresholdHeartrateZones = []float64{85, 90, 95, 100}
+	DefaultPaceZones               = []float64{78, 88, 97, 103}
+)
+
+// ZoneSettings configure heart rate and pace zones.
+type ZoneSettings struct {
+	HeartrateBasis     string    `json:"heartrate_basis"`
+	MaxHeartrate       float64   `json:"max_heartrate"`
+	ThresholdHeartrate float64   `json:"threshold_heartrate"`
+	HeartrateZones     []float64 `json:"heartrate_zones"`
+	ThresholdPace      float64   `json:"threshold_pace"`
+	PaceZones          []float64 `json:"pace_zones"`
+}
+
+// DefaultZoneSettings are used until zones are configured.
+var DefaultZoneSettings = ZoneSettings{
+	HeartrateBasis: HeartrateBasisMax,
+	HeartrateZones: DefaultMaxHeartrateZones,
+	PaceZones:      DefaultPaceZones,
+}
+
+func (z ZoneSettings) Validate() error {
+	switch z.HeartrateBasis {
+	case HeartrateBasisMax, HeartrateBasisThreshold:
+	default:
+		return fmt.Errorf("unknown heartrate_basis: %q", z.HeartrateBasis)
+	}
+	if z.MaxHeartrate < 0 || z.ThresholdHeartrate < 0 || z.ThresholdPace < 0 {
+		return fmt.Errorf("heart rates and threshold pace must not be negative")
+	}
+	if err := validateZoneBoundaries("heartrate_zones", z.HeartrateZones); err != nil {
+		return err
+	}
+	return validateZoneBoundaries("pace_zones", z.PaceZones)
+}
+
+func validateZoneBoundaries(name string, boundaries []float64) error {
+	if len(boundaries) == 0 {
+		return fmt.Errorf("%s must have at least one boundary", name)
+	}
+	for i, boundary := range boundaries {
+		if boundary <= 0 || (i > 0 && boundary <= boundaries[i-1]) {
+			return fmt.Errorf("%s must be positive and increasing", name)
+		}
+	}
+	return nil
+}
+
+// HeartrateBounds returns the zone boundaries in bpm, or nil if unset.
+func (z ZoneSettings) HeartrateBounds() []float64 {
+	basis := z.MaxHeartrate
+	if z.HeartrateBasis == HeartrateBasisThreshold {
+		basis = z.ThresholdHeartrate
+	}
+	if basis == 0 {
+		return nil
+	}
+	bounds := make([]float64, len(z.HeartrateZones))
+	for i, percent := range z.HeartrateZones {
+		bounds[i] = math.Round(basis * percent / 100)
+	}
+	return bounds
+}
+
+// PaceBounds returns the zone boundaries in meters per second, or nil if unset.
+func (z ZoneSettings) PaceBounds() []float64 {
+	if z.ThresholdPace == 0 {
+		return nil
+	}
+	speed := MetersPerMile / z.ThresholdPace
+	bounds := make([]float64, len(z.PaceZones))
+	for i, percent := range z.PaceZones {
+		bounds[i] = speed * percent / 100
+	}
+	return bounds
+}
+
+// ZoneTime is the time spent in one zone, numbered from 1.
+type ZoneTime struct {
+	Zone    int     `json:"zone"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Range   string  `json:"range"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Where a ZoneDistribution's times came from
+const (
+	ZoneSourceStreams  = "streams"
+	ZoneSourceLaps     = "laps"
+	ZoneSourceActivity = "activity"
+)
+
+// ZoneDistribution is the time spent in each heart rate and pace zone.
+type ZoneDistribution struct {
+	Heartrate []ZoneTime `json:"heartrate,omitempty"`
+	Pace      []ZoneTime `json:"pace,omitempty"`
+	Source    string     `json:"source,omitempty"`
+}
+
+// newZoneTimes lays out the zones between bounds; paces run the other way.
+func newZoneTimes(bounds []float64, format func(float64) string, unit string, pace bool) []ZoneTime {
+	below, above := "< %s %s", "%s+ %s"
+	if pace {
+		below, above = "%s+ %s", "< %s %s"
+	}
+	if bounds == nil {
+		return nil
+	}
+	zones := make([]ZoneTime, len(bounds)+1)
+	for i := range zones {
+		zones[i].Zone = i + 1
+		if i > 0 {
+			zones[i].Min = bounds[i-1]
+		}
+		if i < len(bounds) {
+			zones[i].Max = bounds[i]
+		}
+		switch {
+		case i == 0:
+			zones[i].Range = fmt.Sprintf(below, format(zones[i].Max), unit)
+		case i == len(bounds):
+			zones[i].Range = fmt.Sprintf(above, format(zones[i].Min), unit)
+		default:
+			zones[i].Range = fmt.Sprintf("%s-%s %s", format(zones[i].Min), format(zones[i].Max), unit)
+		}
+	}
+	return zones
+}
+
+func formatBpm(bpm float64) string {
+	return fmt.Sprintf("%.0f", bpm)
+}
+
+func formatSpeedAsPace(speed float64) string {
+	return formatPace(3600, metersToMiles(speed*3600))
+}
+
+// NewZoneDistribution returns an empty distribution over the configured zones.
+func NewZoneDistribution(settings ZoneSettings) *ZoneDistribution {
+	return &ZoneDistribution{
+		Heartrate: newZoneTimes(settings.HeartrateBounds(), formatBpm, "bpm", false),
+		Pace:      newZoneTimes(settings.PaceBounds(), formatSpeedAsPace, "/mi", true),
+	}
+}
+
+// addTo adds seconds to the zone that value falls in.
+func addTo(zones []ZoneTime, value, seconds float64) {
+	if len(zones) == 0 || value <= 0 || seconds <= 0 {
+		return
+	}
+	i := 0
+	for i < len(zones)-1 && value >= zones[i].Max {
+		i++
+	}
+	zones[i].Seconds += seconds
+}
+
+// Add sums another distribution over the same zones into d.
+func (d *ZoneDistribution) Add(other *ZoneDistribution) {
+	for i := range d.Heartrate {
+		if i < len(other.Heartrate) {
+			d.Heartrate[i].Seconds += other.Heartrate[i].Seconds
+		}
+	}
+	for i := range d.Pace {
+		if i < len(other.Pace) {
+			d.Pace[i].Seconds += other.Pace[i].Seconds
+		}
+	}
+}
+
+// Samples further apart than this span a pause
+const maxSampleGap = 30
+
+// Slower than this, in meters per second, is standing still
+const minPaceSpeed = 0.5
+
+// ActivityZones computes time in zone from streams, else laps, else the activity.
+func ActivityZones(settings ZoneSettings, activity SummaryActivity, laps []ActivityLap, streams *ActivityStreams) *ZoneDistribution {
+	d := NewZoneDistribution(settings)
+	if !isRun(activity) {
+		d.Pace = nil
+	}
+
+	switch {
+	case streams != nil && len(streams.Time) > 1:
+		d.Source = ZoneSourceStreams
+		for i := 1; i < len(streams.Time); i++ {
+			seconds := streams.Time[i] - streams.Time[i-1]
+			if seconds > maxSampleGap {
+				continue
+			}
+			if i < len(streams.Heartrate) {
+				addTo(d.Heartrate, streams.Heartrate[i], seconds)
+			}
+			if i < len(streams.Velocity) && streams.Velocity[i] >= minPaceSpeed {
+				addTo(d.Pace, streams.Velocity[i], seconds)
+			}
+		}
+	case len(laps) > 0:
+		d.Source = ZoneSourceLaps
+		lapHeartrate := false
+		for _, lap := range laps {
+			addTo(d.Heartrate, lap.AverageHeartrate, float64(lap.MovingTime))
+			lapHeartrate = lapHeartrate || lap.AverageHeartrate > 0
+			if lap.AverageSpeed >= minPaceSpeed {
+				addTo(d.Pace, lap.AverageSpeed, float64(lap.MovingTime))
+			}
+		}
+		if !lapHeartrate && activity.HasHeartrate {
+			addTo(d.Heartrate, activity.AverageHeartrate, activity.MovingTime)
+		}
+	default:
+		d.Source = ZoneSourceActivity
+		if activity.HasHeartrate {
+			addTo(d.Heartrate, activity.AverageHeartrate, activity.MovingTime)
+		}
+		if activity.MovingTime > 0 {
+			addTo(d.Pace, activity.Distance/activity.MovingTime, activity.MovingTime)
+		}
+	}
+	return d
+}
+
+// WeeklyZones sums time in zone by week, keyed like PeriodTotals.Start.
+func WeeklyZones(store Store, settings ZoneSettings, filters ActivityFilter, weekStart time.Weekday) (map[time.Time]*ZoneDistribution, error) {
+	return zonesByPeriod(store, settings, filters, func(date time.Time) time.Time {
+		return weekOf(date, weekStart)
+	})
+}
+
+// MonthlyZones sums time in zone by month.
+func MonthlyZones(store Store, settings ZoneSettings, filters ActivityFilter) (map[time.Time]*ZoneDistribution, error) {
+	return zonesByPeriod(store, settings, filters, monthOf)
+}
+
+func zonesByPeriod(store Store, settings ZoneSettings, filters ActivityFilter, periodStart func(time.Time) time.Time) (map[time.Time]*ZoneDistribution, error) {
+	activities, err := store.Load(filters)
+	if err != nil {
+		return nil, err
+	}
+	laps, err := store.LoadLapsMatching(filters)
+	if err != nil {
+		return nil, err
+	}
+	streams, err := store.LoadStreamsMatching(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	periods := map[time.Time]*ZoneDistribution{}
+	for _, activity := range activities {
+		if activity.StartUTC().IsZero() {
+			continue
+		}
+		zones := ActivityZones(settings, activity, laps[activity.Id], streams[activity.Id])
+		start := periodStart(calendarDay(activity.Date()))
+		if _, ok := periods[start]; !ok {
+			periods[start] = NewZoneDistribution(settings)
+		}
+		periods[start].Add(zones)
+	}
+	return periods, nil
+}
@@ -0,0 +1,94 @@
+package strava_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+func TestActivityZonesSources(t *testing.T) {
+	settings := strava.DefaultZoneSettings
+	settings.MaxHeartrate = 190 // Zones start at 114, 133, 152 and 171 bpm
+
+	run := func(heartrate float64) strava.SummaryActivity {
+		return strava.SummaryActivity{
+			Id:               1,
+			Type:             "Run",
+			Distance:         2000,
+			MovingTime:       600,
+			HasHeartrate:     heartrate > 0,
+			AverageHeartrate: heartrate,
+		}
+	}
+	lap := func(heartrate float64) strava.ActivityLap {
+		return strava.ActivityLap{Distance: 1000, MovingTime: 300, AverageSpeed: 3.3, AverageHeartrate: heartrate}
+	}
+	streams := &strava.ActivityStreams{
+		// The last sample is after a pause and doesn't count
+		Time:      []float64{0, 10, 20, 60},
+		Heartrate: []float64{100, 140, 160, 180},
+	}
+
+	tests := []struct {
+		name      string
+		activity  strava.SummaryActivity
+		laps      []strava.ActivityLap
+		streams   *strava.ActivityStreams
+		source    string
+		heartrate []float64
+	}{
+		{"streams", run(170), []strava.ActivityLap{lap(120)}, streams, strava.ZoneSourceStreams, []float64{0, 0, 10, 10, 0}},
+		{"too few samples", run(170), []strava.ActivityLap{lap(120), lap(155)}, &strava.ActivityStreams{Time: []float64{0}}, strava.ZoneSourceLaps, []float64{0, 300, 0, 300, 0}},
+		{"laps", run(170), []strava.ActivityLap{lap(120), lap(155)}, nil, strava.ZoneSourceLaps, []float64{0, 300, 0, 300, 0}},
+		{"laps without heart rate", run(150), []strava.ActivityLap{lap(0), lap(0)}, nil, strava.ZoneSourceLaps, []float64{0, 0, 600, 0, 0}},
+		{"activity", run(160), nil, nil, strava.ZoneSourceActivity, []float64{0, 0, 0, 600, 0}},
+		{"no heart rate", run(0), nil, nil, strava.ZoneSourceActivity, []float64{0, 0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := strava.ActivityZones(settings, test.activity, test.laps, test.streams)
+			heartrate := []float64{}
+			for _, zone := range d.Heartrate {
+				heartrate = append(heartrate, zone.Seconds)
+			}
+			if d.Source != test.source || fmt.Sprint(heartrate) != fmt.Sprint(test.heartrate) {
+				t.Errorf("got %s %v, want %s %v", d.Source, heartrate, test.source, test.heartrate)
+			}
+		})
+	}
+}
+
+func TestWeeklyZonesLoadsDetailsInBulk(t *testing.T) {
+	settings := strava.DefaultZoneSettings
+	settings.MaxHeartrate = 190
+
+	forEachStore(t, func(t *testing.T, store strava.Store) {
+		server := newServer(t, 3)
+		client := newClient(t, server, store)
+		if err := client.Reconcile(context.Background(), store); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveStreams(2, &strava.ActivityStreams{
+			Time:      []float64{0, 10, 20},
+			Heartrate: []float64{140, 140, 140},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		zones, err := strava.WeeklyZones(store, settings, strava.ActivityFilter{}, time.Monday)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Runs 1 to 3 are on Jan 2 to 4, 2023, the week of Jan 2
+		week := zones[time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)]
+		if week == nil {
+			t.Fatalf("no zones for the week of Jan 2: %v", zones)
+		}
+		if got := week.Heartrate[2].Seconds; got != 20 {
+			t.Errorf("zone 3 has %v seconds, want 20 from activity 2's streams", got)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/scottfrazer/website/strava"
+)
+
+// routeSVGOptions are how a route thumbnail is drawn.  Size is the width and
+// height in pixels, Stroke the line width in pixels and Color a hex color.
+type routeSVGOptions struct {
+	Size   int
+	Stroke float64
+	Color  string
+}
+
+var defaultRouteSVGOptions = routeSVGOptions{Size: 64, Stroke: 2, Color: "#fc4c02"}
+
+var hexColor = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// routeSVGOptionsFromQuery reads routeSVGOptions from the query parameters
+// size, stroke and color, which default to defaultRouteSVGOptions.
+func routeSVGOptionsFromQuery(query url.Values) (routeSVGOptions, error) {
+	opts := defaultRouteSVGOptions
+	if value := query.Get("size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 8 || size > 1024 {
+			return opts, fmt.Errorf("invalid size: %s", value)
+		}
+		opts.Size = size
+	}
+	if value := query.Get("stroke"); value != "" {
+		stroke, err := strconv.ParseFloat(value, 64)
+		if err != nil || stroke <= 0 || stroke > float64(opts.Size)/4 {
+			return opts, fmt.Errorf("invalid stroke: %s", value)
+		}
+		opts.Stroke = stroke
+	}
+	if value := query.Get("color"); value != "" {
+		if !hexColor.MatchString(value) {
+			return opts, fmt.Errorf("invalid color: %s", value)
+		}
+		opts.Color = "#" + strings.TrimPrefix(value, "#")
+	}
+	return opts, nil
+}
+
+// renderRouteSVG draws a route centered in a square, projected with web
+// mercator and simplified to about half a pixel.  points must not be empty.
+func renderRouteSVG(points []strava.LatLng, opts routeSVGOptions) []byte {
+	projected := make([]strava.Point, len(points))
+	for i, p := range points {
+		projected[i] = strava.Mercator(p)
+	}
+	min, max := projected[0], projected[0]
+	for _, p := range projected {
+		min = strava.Point{X: math.Min(min.X, p.X), Y: math.Min(min.Y, p.Y)}
+		max = strava.Point{X: math.Max(max.X, p.X), Y: math.Max(max.Y, p.Y)}
+	}
+
+	// Leave room for the stroke and keep the aspect ratio
+	size := float64(opts.Size)
+	inner := size - 2*opts.Stroke
+	scale := 0.0
+	if extent := math.Max(max.X-min.X, max.Y-min.Y); extent > 0 {
+		scale = inner / extent
+	}
+	offsetX := (size - (max.X-min.X)*scale) / 2
+	offsetY := (size - (max.Y-min.Y)*scale) / 2
+	for i, p := range projected {
+		projected[i] = strava.Point{X: offsetX + (p.X-min.X)*scale, Y: offsetY + (p.Y-min.Y)*scale}
+	}
+	projected = strava.Simplify(projected, 0.5)
+
+	var path strings.Builder
+	for i, p := range projected {
+		command := "L"
+		if i == 0 {
+			command = "M"
+		}
+		fmt.Fprintf(&path, "%s%s %s", command, formatSVGNumber(p.X), formatSVGNumber(p.Y))
+	}
+	if len(projected) == 1 {
+		// A lone point still needs a segment for the round cap to draw a dot
+		path.WriteString("h0")
+	}
+
+	return []byte(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+
+			`<path d="%s" fill="none" stroke="%s" stroke-width="%s" stroke-linecap="round" stroke-linejoin="round"/>`+
+			`</svg>`,
+		opts.Size, opts.Size, opts.Size, opts.Size,
+		path.String(), opts.Color, formatSVGNumber(opts.Stroke),
+	))
+}
+
+func formatSVGNumber(n float64) string {
+	return strconv.FormatFloat(math.Round(n*10)/10, 'f', -1, 64)
+}
+
+// Enough for a few pages of the running list at a couple of sizes
+const routeSVGCacheSize = 2000
+
+type routeSVGKey struct {
+	ActivityId int64
+	Options    routeSVGOptions
+}
+
+type routeSVGEntry struct {
+	polyline string
+	svg      []byte
+}
+
+// routeSVGCache holds rendered thumbnails by activity and options.  Entries
+// remember the polyline they were drawn from, so an activity whose route
+// changes is redrawn.
+type routeSVGCache struct {
+	mu      sync.Mutex
+	entries map[routeSVGKey]routeSVGEntry
+}
+
+func newRouteSVGCache() *routeSVGCache {
+	return &routeSVGCache{entries: map[routeSVGKey]routeSVGEntry{}}
+}
+
+// Get returns the activity's thumbnail, or nil if it has no route.
+func (c *routeSVGCache) Get(activity *strava.SummaryActivity, opts routeSVGOptions) ([]byte, error) {
+	key := routeSVGKey{activity.Id, opts}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && entry.polyline == activity.Map.Polyline {
+		return entry.svg, nil
+	}
+
+	points, err := activity.Route()
+	if err != nil || len(points) == 0 {
+		return nil, err
+	}
+	entry = routeSVGEntry{polyline: activity.Map.Polyline, svg: renderRouteSVG(points, opts)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= routeSVGCacheSize {
+		c.entries = map[routeSVGKey]routeSVGEntry{}
+	}
+	c.entries[key] = entry
+	return entry.svg, nil
+}
@@ -166,20 +166,16 @@ function RunningStats(props) {
 
 function Running(props) {
   const [activities, setActivities] = useState([]);
+  const [next, setNext] = useState(undefined);
   const [error, setError] = useState([]);
+  const navigate = useNavigate();
 
   const { search } = useLocation();
   const queryParams = new URLSearchParams(search);
-  var { page: pageFromQuery, perPage: perPageFromQuery } = Object.fromEntries(queryParams.entries());
+  var { cursor: cursorFromQuery, perPage: perPageFromQuery } = Object.fromEntries(queryParams.entries());
 
   const isNumeric = (value) => !isNaN(value);
 
-  if (!isNumeric(pageFromQuery)) {
-    pageFromQuery = 1
-  } else {
-    pageFromQuery = parseInt(pageFromQuery)
-  }
-
   if (!isNumeric(perPageFromQuery)) {
     perPageFromQuery = 50
   } else {
@@ -187,23 +183,25 @@ function Running(props) {
   }
 
   useEffect(() => {
-    apiRequest(`/running/list?page=${pageFromQuery}&perPage=${perPageFromQuery}`)
+    const cursorParam = cursorFromQuery ? `&cursor=${cursorFromQuery}` : ''
+    apiRequest(`/running/list?perPage=${perPageFromQuery}${cursorParam}`)
       .then(response => response.json())
       .then(data => {
         if (data.error) {
           setError(data.error)
         } else {
-          data = data.map(p => {
-            const s = moment(p.date).utc()
+          const activities = data.activities.map(p => {
+            const s = moment.parseZone(p.date)
             p.date = s.format('YYYY-MM-DD')
             p.time = s.format('hh:mm')
             return p
           })
           setError(undefined)
-          setActivities(data)
+          setActivities(activities)
+          setNext(data.next)
         }
       })
-  }, [pageFromQuery, perPageFromQuery])
+  }, [cursorFromQuery, perPageFromQuery])
 
   if (error !== undefined) {
     return <div>Error: {error}</div>
@@ -212,9 +210,9 @@ function Running(props) {
   return <div className="running">
     <p>I like to run. Below is a list of every run I've done.  I also have <Link to='/running/stats'>stats</Link></p>
     <div>
-      {pageFromQuery === 1 ? <span>prev</span> : <Link to={{ pathname: '/running', search: `?page=${pageFromQuery - 1}&perPage=${perPageFromQuery}` }}>prev</Link>}
+      {cursorFromQuery === undefined ? <span>prev</span> : <a href="#prev" onClick={(e) => { e.preventDefault(); navigate(-1) }}>prev</a>}
       &nbsp;|&nbsp;
-      {activities.length !== perPageFromQuery ? <span>next</span> : <Link to={{ pathname: '/running', search: `?page=${pageFromQuery + 1}&perPage=${perPageFromQuery}` }}>next</Link>}
+      {next === undefined ? <span>next</span> : <Link to={{ pathname: '/running', search: `?cursor=${next}&perPage=${perPageFromQuery}` }}>next</Link>}
     </div>
     <table>
       <thead>
@@ -226,6 +224,7 @@ function Running(props) {
           <td>Type</td>
           <td>Pace</td>
           <td>Time</td>
+          <td>Route</td>
           <td>Link</td>
         </tr>
       </thead>
@@ -239,6 +238,7 @@ function Running(props) {
             <td>{activity.type}</td>
             <td>{activity.pace}/mi</td>
             <td>{activity.moving_time}</td>
+            <td>{activity.thumbnail_url && <img src={baseUrl + activity.thumbnail_url + '?size=32'} width="32" height="32" alt="" />}</td>
             <td><a href={`https://strava.com/activities/${activity.id}`}><FontAwesomeIcon icon={faCoffee} /></a></td>
           </tr>
         ))}